@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Async returns a Decorator that sends a Client's requests in the background
+// and immediately returns a synthetic 202 Accepted response. The request body
+// is copied and the request context is detached from its cancellation so the
+// background call outlives the caller. Errors, including those from copying
+// the body, are reported to onError, which may be nil.
+//
+// The returned Client is a Shutdowner that waits for in-flight calls.
+func Async(onError func(*http.Request, error)) Decorator {
+	return func(c Client) Client {
+		return &asyncClient{client: c, onError: onError}
+	}
+}
+
+// asyncClient is the Client returned by an Async Decorator.
+type asyncClient struct {
+	client   Client
+	onError  func(*http.Request, error)
+	inflight sync.WaitGroup
+}
+
+// Do dispatches r in the background and returns a 202 Accepted response.
+func (a *asyncClient) Do(r *http.Request) (*http.Response, error) {
+	detached := r.Clone(context.WithoutCancel(r.Context()))
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		detached.Body = io.NopCloser(bytes.NewReader(body))
+		detached.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	a.inflight.Add(1)
+	go func() {
+		defer a.inflight.Done()
+		res, err := a.client.Do(detached)
+		if err != nil {
+			if a.onError != nil {
+				a.onError(detached, err)
+			}
+			return
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}()
+
+	return &http.Response{
+		Status:     "202 Accepted",
+		StatusCode: http.StatusAccepted,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    r,
+	}, nil
+}
+
+// Unwrap returns the Client that a decorates.
+func (a *asyncClient) Unwrap() Client {
+	return a.client
+}
+
+// Shutdown waits for the in-flight background calls to finish.
+func (a *asyncClient) Shutdown(ctx context.Context) error {
+	return wait(ctx, &a.inflight)
+}
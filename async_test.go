@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAsync(t *testing.T) {
+	var calls atomic.Int32
+	var body atomic.Value
+	release := make(chan struct{})
+	inner := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		<-release
+		b, _ := io.ReadAll(r.Body)
+		body.Store(string(b))
+		calls.Add(1)
+		return newResponse(r, http.StatusOK, "ignored"), nil
+	})
+	c := Decorate(inner, Async(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("payload")).WithContext(ctx)
+	res := do(t, c, r)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("StatusCode = %d, want %d", res.StatusCode, http.StatusAccepted)
+	}
+	cancel()
+	close(release)
+
+	if err := Shutdown(context.Background(), c); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("background calls = %d, want 1", calls.Load())
+	}
+	if got := body.Load(); got != "payload" {
+		t.Fatalf("background body = %q, want %q", got, "payload")
+	}
+}
+
+func TestAsyncReportsErrors(t *testing.T) {
+	errc := make(chan error, 1)
+	c := Decorate(ClientFunc(func(*http.Request) (*http.Response, error) {
+		return nil, io.ErrUnexpectedEOF
+	}), Async(func(_ *http.Request, err error) { errc <- err }))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if err := <-errc; err != io.ErrUnexpectedEOF {
+		t.Fatalf("onError got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// BackendFromContext returns the backend that the LoadBalancing Decorator
+// chose for the request with the given context, if any.
+func BackendFromContext(ctx context.Context) (string, bool) {
+	backend, ok := ctx.Value(backendKey).(string)
+	return backend, ok
+}
+
+// ServedBy returns a Decorator that sets the given header on every response
+// to the backend that served it, so callers can observe routing. It must be
+// wrapped by the LoadBalancing Decorator, i.e. come before it in Decorate,
+// to see the backend chosen by the Director.
+func ServedBy(header string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			backend := r.URL.Host
+			res, err := c.Do(r)
+			if res != nil {
+				if res.Header == nil {
+					res.Header = http.Header{}
+				}
+				res.Header.Set(header, backend)
+			}
+			return res, err
+		})
+	}
+}
+
+// BackendInstrumentation returns a Decorator that instruments a Client with
+// the given metrics labeled by the backend of each request, under the
+// "backend" label. Like ServedBy, it must be wrapped by the LoadBalancing
+// Decorator to see the backend chosen by the Director.
+func BackendInstrumentation(requests CounterVec, latency HistogramVec) Decorator {
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			labels := map[string]string{"backend": r.URL.Host}
+			defer func(start time.Time) {
+				latency.With(labels).Observe(time.Since(start).Nanoseconds())
+				requests.With(labels).Add(1)
+			}(time.Now())
+			return c.Do(r)
+		}, requests, latency)
+	}
+}
+
+// FollowBackendHint returns a Decorator that resends a request to the backend
+// named by the given response header, e.g. X-Redirect-Backend, whenever a
+// response carries it, following at most max hints per request. Only hints
+// naming one of the given backends are followed, as the request is resent
+// with its credentials; responses hinting at any other host are returned as
+// they are. Requests whose body can't be resent because they lack GetBody
+// aren't followed either.
+func FollowBackendHint(header string, max int, backends ...string) Decorator {
+	allowed := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		allowed[backend] = true
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			req := r
+			for hints := 0; ; hints++ {
+				res, err := c.Do(req)
+				if err != nil {
+					return res, err
+				}
+				backend := res.Header.Get(header)
+				if backend == "" || backend == req.URL.Host || !allowed[backend] || hints >= max {
+					return res, nil
+				}
+				if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+					return res, nil
+				}
+
+				next := req.Clone(req.Context())
+				next.URL.Host = backend
+				if req.GetBody != nil {
+					if next.Body, err = req.GetBody(); err != nil {
+						res.Body.Close()
+						return nil, err
+					}
+				}
+				drainAndClose(res.Body)
+				req = next
+			}
+		})
+	}
+}
+
+// SetHost returns a Decorator that sets the Host of every request, i.e. the
+// virtual host the server sees, so it keeps naming the logical host when a
+// Director routes requests by IP address. The TLS server name is still taken
+// from the URL by http.Transport; set its TLSClientConfig.ServerName to match.
+func SetHost(host string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Host = host
+			return c.Do(r)
+		})
+	}
+}
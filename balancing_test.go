@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServedBy(t *testing.T) {
+	c := Decorate(respond(http.StatusOK, ""), ServedBy("X-Served-By"), LoadBalancing(RoundRobin(0, "b1", "b2")))
+	for _, want := range []string{"b2", "b1", "b2"} {
+		res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+		res.Body.Close()
+		if got := res.Header.Get("X-Served-By"); got != want {
+			t.Fatalf("X-Served-By = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestBackendInstrumentation(t *testing.T) {
+	requests := NewCounterVec("requests")
+	latency := NewHistogramVec("latency", 0, int64(time.Minute), 0, 100)
+	c := Decorate(respond(http.StatusOK, ""), BackendInstrumentation(requests, latency), LoadBalancing(RoundRobin(0, "b1", "b2")))
+	for i := 0; i < 3; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	}
+	for backend, want := range map[string]uint64{"b1": 1, "b2": 2} {
+		labels := map[string]string{"backend": backend}
+		if got := requests.Value(labels); got != want {
+			t.Errorf("requests to %s = %d, want %d", backend, got, want)
+		}
+		if _, count, _ := latency.histogram(labels).summary(); uint64(count) != want {
+			t.Errorf("latencies of %s = %d, want %d", backend, count, want)
+		}
+	}
+}
+
+func TestFollowBackendHint(t *testing.T) {
+	var hosts []string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		res := newResponse(r, http.StatusOK, "")
+		switch r.URL.Host {
+		case "b1":
+			res.Header.Set("X-Backend", "b2")
+		case "b2", "b3":
+			res.Header.Set("X-Backend", "b3")
+		}
+		return res, nil
+	}), FollowBackendHint("X-Backend", 1, "b1", "b2", "b3"))
+
+	do(t, c, newRequest(t, http.MethodGet, "http://b1/", nil)).Body.Close()
+	if want := []string{"b1", "b2"}; !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("sent to %v, want %v", hosts, want)
+	}
+
+	hosts = nil
+	do(t, c, newRequest(t, http.MethodPost, "http://b1/", unseekable("body"))).Body.Close()
+	if want := []string{"b1"}; !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("body without GetBody: sent to %v, want %v", hosts, want)
+	}
+}
+
+func TestFollowBackendHintForeignHost(t *testing.T) {
+	var sent []string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		sent = append(sent, r.URL.Host+" "+r.Header.Get("Authorization"))
+		res := newResponse(r, http.StatusOK, "")
+		res.Header.Set("X-Backend", "169.254.169.254")
+		return res, nil
+	}), FollowBackendHint("X-Backend", 3, "b1", "b2"))
+
+	r := newRequest(t, http.MethodGet, "http://b1/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	res := do(t, c, r)
+	res.Body.Close()
+	if want := []string{"b1 Bearer secret"}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent %v, want %v without following the foreign hint", sent, want)
+	}
+	if got := res.Header.Get("X-Backend"); got != "169.254.169.254" {
+		t.Fatalf("X-Backend = %q, want the hinting response returned", got)
+	}
+}
+
+func TestSetHost(t *testing.T) {
+	var host, backend string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		host, backend = r.Host, r.URL.Host
+		return newResponse(r, http.StatusOK, ""), nil
+	}), LoadBalancing(RoundRobin(0, "10.0.0.1")), SetHost("api.example.com"))
+	do(t, c, newRequest(t, http.MethodGet, "http://api.example.com/", nil)).Body.Close()
+	if host != "api.example.com" || backend != "10.0.0.1" {
+		t.Fatalf("sent to %s with Host %q, want 10.0.0.1 with Host api.example.com", backend, host)
+	}
+}
+
+func TestBackendFromContext(t *testing.T) {
+	var got []string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		backend, _ := BackendFromContext(r.Context())
+		got = append(got, backend)
+		return newResponse(r, http.StatusOK, ""), nil
+	}), LoadBalancing(RoundRobin(0, "b1", "b2")))
+	for i := 0; i < 2; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	}
+	if want := []string{"b2", "b1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("backends in context = %v, want %v", got, want)
+	}
+	if _, ok := BackendFromContext(context.Background()); ok {
+		t.Fatal("BackendFromContext reported a backend for a bare context")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by quota enforcing Decorators once their
+// quota is used up.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// GlobalBandwidthLimit returns a Decorator that counts the response bytes read
+// through a Client across all its requests and, once maxBytes have been read,
+// fails further reads and requests with ErrQuotaExceeded. A single read may
+// take the total past maxBytes.
+func GlobalBandwidthLimit(maxBytes int64) Decorator {
+	return func(c Client) Client {
+		var total atomic.Int64
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if n := total.Load(); n >= maxBytes {
+				return nil, fmt.Errorf("%w: read %d bytes of %d", ErrQuotaExceeded, n, maxBytes)
+			}
+			res, err := c.Do(r)
+			if err != nil {
+				return res, err
+			}
+			res.Body = &quotaBody{ReadCloser: res.Body, total: &total, max: maxBytes}
+			return res, nil
+		})
+	}
+}
+
+// quotaBody is a response body that adds the bytes read from it to a shared
+// total and fails once the total goes over max.
+type quotaBody struct {
+	io.ReadCloser
+	total *atomic.Int64
+	max   int64
+}
+
+func (b *quotaBody) Read(p []byte) (int, error) {
+	if n := b.total.Load(); n >= b.max {
+		return 0, fmt.Errorf("%w: read %d bytes of %d", ErrQuotaExceeded, n, b.max)
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.total.Add(int64(n))
+	return n, err
+}
+
+// ThrottleBandwidth returns a Decorator that slows down the reading of every
+// response body to at most bytesPerSec bytes per second. A non-positive
+// bytesPerSec disables the limit.
+func ThrottleBandwidth(bytesPerSec int) Decorator {
+	return func(c Client) Client {
+		if bytesPerSec <= 0 {
+			return c
+		}
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil {
+				return res, err
+			}
+			res.Body = &throttledBody{ReadCloser: res.Body, ctx: r.Context(), rate: bytesPerSec}
+			return res, nil
+		})
+	}
+}
+
+// throttledBody is a response body read at no more than rate bytes per second.
+type throttledBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	rate  int
+	start time.Time
+	read  int64
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	clock := clockFrom(b.ctx)
+	if b.start.IsZero() {
+		b.start = clock.Now()
+	}
+	// Reading at most a tenth of a second worth of bytes at a time keeps the
+	// pace smooth.
+	if chunk := b.rate/10 + 1; len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	due := b.start.Add(time.Duration(float64(b.read) / float64(b.rate) * float64(time.Second)))
+	if serr := sleep(b.ctx, due.Sub(clock.Now())); serr != nil && err == nil {
+		err = serr
+	}
+	return n, err
+}
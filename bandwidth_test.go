@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGlobalBandwidthLimit(t *testing.T) {
+	c := Decorate(respond(http.StatusOK, "12345"), GlobalBandwidthLimit(8))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "12345" {
+		t.Fatalf("first body = %q, want 12345", got)
+	}
+
+	res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if _, err := io.ReadAll(res.Body); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("reading past the quota: error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	res.Body.Close()
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Do() past the quota: error = %v, want %v", err, ErrQuotaExceeded)
+	}
+}
+
+func TestThrottleBandwidth(t *testing.T) {
+	for _, tc := range []struct {
+		bytesPerSec int
+		want        time.Duration
+	}{
+		{5, 2 * time.Second},
+		{0, 0},
+		{-1, 0},
+	} {
+		clock := newFakeClock()
+		c := Decorate(respond(http.StatusOK, "0123456789"), ThrottleBandwidth(tc.bytesPerSec), WithClock(clock))
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "0123456789" {
+			t.Fatalf("%d B/s: body = %q, want it whole", tc.bytesPerSec, got)
+		}
+		var waited time.Duration
+		for _, d := range clock.Waits() {
+			waited += d
+		}
+		if waited != tc.want {
+			t.Errorf("%d B/s: waited %v, want %v", tc.bytesPerSec, waited, tc.want)
+		}
+	}
+}
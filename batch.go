@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Batcher combines individual requests into a single batched request and
+// splits the batched response back into one response per request.
+type Batcher interface {
+	// Merge returns the batched request for the given requests.
+	Merge(rs []*http.Request) (*http.Request, error)
+	// Split returns the response for each of the given requests, in order.
+	Split(res *http.Response, rs []*http.Request) ([]*http.Response, error)
+}
+
+// Batching returns a Decorator that collects a Client's requests to the same
+// endpoint and sends them upstream as a single batch built by the given
+// Batcher. A batch is sent once it holds maxSize requests or maxDelay has
+// passed since its first request, whichever comes first.
+func Batching(b Batcher, maxSize int, maxDelay time.Duration) Decorator {
+	return func(c Client) Client {
+		bc := &batchClient{
+			client:   c,
+			batcher:  b,
+			maxSize:  maxSize,
+			maxDelay: maxDelay,
+			pending:  map[string]*batch{},
+		}
+		return bc
+	}
+}
+
+// batchClient holds the batches being collected by a Batching Decorator,
+// keyed by endpoint.
+type batchClient struct {
+	client   Client
+	batcher  Batcher
+	maxSize  int
+	maxDelay time.Duration
+
+	mu       sync.Mutex
+	pending  map[string]*batch
+	inflight sync.WaitGroup
+}
+
+// batch is a set of requests to the same endpoint waiting to be sent.
+type batch struct {
+	key     string
+	timer   *time.Timer
+	reqs    []*http.Request
+	results []chan batchResult
+}
+
+type batchResult struct {
+	res *http.Response
+	err error
+}
+
+// Do adds r to the pending batch for its endpoint and waits for its response.
+func (bc *batchClient) Do(r *http.Request) (*http.Response, error) {
+	key := r.Method + " " + r.URL.Scheme + "://" + r.URL.Host + r.URL.Path
+	result := make(chan batchResult, 1)
+
+	bc.mu.Lock()
+	b, ok := bc.pending[key]
+	if !ok {
+		b = &batch{key: key}
+		b.timer = time.AfterFunc(bc.maxDelay, func() { bc.flush(b) })
+		bc.pending[key] = b
+	}
+	b.reqs = append(b.reqs, r)
+	b.results = append(b.results, result)
+	full := len(b.reqs) >= bc.maxSize
+	bc.mu.Unlock()
+
+	if full {
+		bc.flush(b)
+	}
+
+	select {
+	case res := <-result:
+		return res.res, res.err
+	case <-r.Context().Done():
+		go func() {
+			if res := <-result; res.err == nil {
+				res.res.Body.Close()
+			}
+		}()
+		return nil, r.Context().Err()
+	}
+}
+
+// flush sends b upstream and delivers the split responses. Only the first
+// call for a given batch has any effect.
+func (bc *batchClient) flush(b *batch) {
+	bc.mu.Lock()
+	if bc.pending[b.key] != b {
+		bc.mu.Unlock()
+		return
+	}
+	delete(bc.pending, b.key)
+	b.timer.Stop()
+	bc.inflight.Add(1)
+	bc.mu.Unlock()
+
+	defer bc.inflight.Done()
+	bc.deliver(b)
+}
+
+// Unwrap returns the Client that bc decorates.
+func (bc *batchClient) Unwrap() Client {
+	return bc.client
+}
+
+// Shutdown sends every pending batch without waiting for it to fill up and
+// waits for the in-flight batches to complete.
+func (bc *batchClient) Shutdown(ctx context.Context) error {
+	bc.mu.Lock()
+	for key, b := range bc.pending {
+		delete(bc.pending, key)
+		b.timer.Stop()
+		bc.inflight.Add(1)
+		go func(b *batch) {
+			defer bc.inflight.Done()
+			bc.deliver(b)
+		}(b)
+	}
+	bc.mu.Unlock()
+	return wait(ctx, &bc.inflight)
+}
+
+// deliver sends b upstream and hands each caller its response.
+func (bc *batchClient) deliver(b *batch) {
+	responses, err := bc.send(b.reqs)
+	for i, result := range b.results {
+		if err != nil {
+			result <- batchResult{err: err}
+		} else {
+			result <- batchResult{res: responses[i]}
+		}
+	}
+}
+
+func (bc *batchClient) send(rs []*http.Request) ([]*http.Response, error) {
+	merged, err := bc.batcher.Merge(rs)
+	if err != nil {
+		return nil, err
+	}
+	res, err := bc.client.Do(merged)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := bc.batcher.Split(res, rs)
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) != len(rs) {
+		return nil, fmt.Errorf("batch: split %d requests into %d responses", len(rs), len(responses))
+	}
+	return responses, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineBatcher merges requests into one whose body has their queries on
+// separate lines, and splits a response with one line per request.
+type lineBatcher struct{}
+
+func (lineBatcher) Merge(rs []*http.Request) (*http.Request, error) {
+	var queries []string
+	for _, r := range rs {
+		queries = append(queries, r.URL.RawQuery)
+	}
+	return http.NewRequest(http.MethodPost, "http://example.com/batch", strings.NewReader(strings.Join(queries, "\n")))
+}
+
+func (lineBatcher) Split(res *http.Response, rs []*http.Request) ([]*http.Response, error) {
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var responses []*http.Response
+	for i, line := range strings.Split(string(body), "\n") {
+		responses = append(responses, newResponse(rs[i], http.StatusOK, line))
+	}
+	return responses, nil
+}
+
+// echoBatch answers a batch with its own body, counting the batches sent.
+func echoBatch(batches *int) Client {
+	var mu sync.Mutex
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		*batches++
+		mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		return newResponse(r, http.StatusOK, string(bytes.ToUpper(body))), nil
+	})
+}
+
+func TestBatching(t *testing.T) {
+	var batches int
+	c := Decorate(echoBatch(&batches), Batching(lineBatcher{}, 3, time.Hour))
+
+	var wg sync.WaitGroup
+	got := make([]string, 3)
+	for i, query := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(i int, query string) {
+			defer wg.Done()
+			got[i] = bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/item?"+query, nil)))
+		}(i, query)
+	}
+	wg.Wait()
+	if batches != 1 {
+		t.Fatalf("sent %d batches, want 1", batches)
+	}
+	for i, want := range []string{"A", "B", "C"} {
+		if got[i] != want {
+			t.Errorf("response %d = %q, want %q", i, got[i], want)
+		}
+	}
+}
+
+// closeBatcher is a lineBatcher whose split responses report their closing
+// on closed.
+type closeBatcher struct {
+	lineBatcher
+	closed chan struct{}
+}
+
+func (b closeBatcher) Split(res *http.Response, rs []*http.Request) ([]*http.Response, error) {
+	responses, err := b.lineBatcher.Split(res, rs)
+	for _, res := range responses {
+		res.Body = &cancelBody{ReadCloser: res.Body, cancel: func() { close(b.closed) }}
+	}
+	return responses, err
+}
+
+func TestBatchingClosesAbandonedResponses(t *testing.T) {
+	var batches int
+	b := closeBatcher{closed: make(chan struct{})}
+	c := Decorate(echoBatch(&batches), Batching(b, 2, time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := newRequest(t, http.MethodGet, "http://example.com/item?a", nil).WithContext(ctx)
+	if _, err := c.Do(r); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do() error = %v, want %v", err, context.Canceled)
+	}
+	if err := Shutdown(context.Background(), c); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	select {
+	case <-b.closed:
+	case <-time.After(time.Second):
+		t.Fatal("the abandoned response body was never closed")
+	}
+}
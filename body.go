@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BufferRequestBody returns a Decorator that reads every request body of up
+// to max bytes into memory and sets the request's GetBody, so that the
+// retrying Decorators it wraps can resend it. Larger bodies fail with
+// ErrRequestTooLarge.
+func BufferRequestBody(max int64) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+				return c.Do(r)
+			}
+			if err := bufferBody(r, max); err != nil {
+				return nil, err
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// CaptureBodyOnError returns a Decorator that keeps a copy of every request
+// body and writes it to w, after a line naming the request and its outcome,
+// only when the Client fails with an error or a 5xx response. Only the first
+// 10 MiB of a body are kept, and the Client still sends it in full.
+func CaptureBodyOnError(w io.Writer) Decorator {
+	var mu sync.Mutex
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			body, _, err := peekBody(r, maxBufferedBody)
+			if err != nil {
+				return nil, err
+			}
+			res, err := c.Do(r)
+			var outcome string
+			switch {
+			case err != nil:
+				outcome = "error: " + err.Error()
+			case res.StatusCode >= 500:
+				outcome = res.Status
+			default:
+				return res, nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(w, "%s %s (%s)\n%s\n", r.Method, r.URL, outcome, body)
+			return res, err
+		})
+	}
+}
+
+// RetryUploads returns a Decorator that retries requests whose body fails to
+// be sent in full, up to attempts times with the same backoff schedule as
+// FaultTolerance, resending the whole body each time. Bodies without GetBody
+// are buffered first, up to maxBody bytes; larger ones fail with
+// ErrRequestTooLarge. Errors after the body was sent, e.g. while waiting for
+// the response, aren't retried.
+func RetryUploads(attempts int, backoff time.Duration, maxBody int64) Decorator {
+	return func(c Client) Client {
+		tracked := ClientFunc(func(r *http.Request) (*http.Response, error) {
+			body := &trackedBody{ReadCloser: r.Body}
+			r.Body = body
+			res, err := c.Do(r)
+			if err != nil && !body.sent.Load() {
+				return nil, &uploadError{err}
+			}
+			return res, err
+		})
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body == nil || r.Body == http.NoBody {
+				return c.Do(r)
+			}
+			if r.GetBody == nil {
+				if err := bufferBody(r, maxBody); err != nil {
+					return nil, err
+				}
+			}
+			return retry(tracked, r, attempts, linear(backoff), func(_ *http.Response, err error) bool {
+				var upload *uploadError
+				return errors.As(err, &upload)
+			})
+		})
+	}
+}
+
+// UploadProgress returns a Decorator that calls progress as every request
+// body is read for sending, with the number of bytes sent so far and the
+// total, which is the request's ContentLength, -1 when unknown. A body resent
+// through GetBody reports its progress from zero again.
+func UploadProgress(progress func(sent, total int64)) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body == nil || r.Body == http.NoBody {
+				return c.Do(r)
+			}
+			total := r.ContentLength
+			if total == 0 {
+				total = -1
+			}
+			r.Body = &progressBody{ReadCloser: r.Body, total: total, progress: progress}
+			if getBody := r.GetBody; getBody != nil {
+				r.GetBody = func() (io.ReadCloser, error) {
+					body, err := getBody()
+					if err != nil {
+						return nil, err
+					}
+					return &progressBody{ReadCloser: body, total: total, progress: progress}, nil
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// progressBody is a request body that reports how much of it was read.
+type progressBody struct {
+	io.ReadCloser
+	sent, total int64
+	progress    func(sent, total int64)
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.sent += int64(n)
+		b.progress(b.sent, b.total)
+	}
+	return n, err
+}
+
+// trackedBody is a request body that records whether it was read to the end.
+type trackedBody struct {
+	io.ReadCloser
+	sent atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.sent.Store(true)
+	}
+	return n, err
+}
+
+// uploadError is an error that happened before a request body was sent in
+// full.
+type uploadError struct {
+	err error
+}
+
+func (e *uploadError) Error() string { return e.err.Error() }
+func (e *uploadError) Unwrap() error { return e.err }
+
+// bufferBody replaces the body of r with an in-memory copy of up to max
+// bytes and sets GetBody and ContentLength accordingly.
+func bufferBody(r *http.Request, max int64) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > max {
+		return fmt.Errorf("%w: more than %d bytes to buffer", ErrRequestTooLarge, max)
+	}
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return nil
+}
+
+// prefixedBody is a body whose first bytes were read ahead, which Reader
+// reads again before the rest.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// maxBufferedBody is the number of bytes of a body that the Decorators
+// buffering whole bodies hold at most, unless configured otherwise.
+const maxBufferedBody = 10 << 20
+
+// readLimited reads body in full and closes it, unless it is longer than max
+// bytes, in which case it returns a nil slice along with a body that reads
+// the same as body did. body is closed on error.
+func readLimited(body io.ReadCloser, max int64) ([]byte, io.ReadCloser, error) {
+	data, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, &prefixedBody{Reader: io.MultiReader(bytes.NewReader(data), body), Closer: body}, nil
+	}
+	body.Close()
+	return data, nil, nil
+}
+
+// readBody returns the body of r, leaving r with a body that reads the same
+// and a GetBody, unless it had one already.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return body, nil
+}
+
+// peekBody returns up to max bytes from the start of the body of r and
+// whether they are all of it, leaving r with a body that reads the same.
+// Bodies read in full get a GetBody, unless r had one already.
+func peekBody(r *http.Request, max int64) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, false, err
+		}
+		defer body.Close()
+		data, err := io.ReadAll(io.LimitReader(body, max+1))
+		if err != nil {
+			return nil, false, err
+		}
+		if int64(len(data)) > max {
+			return data[:max], false, nil
+		}
+		return data, true, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		r.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(data)) > max {
+		r.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(data), r.Body), Closer: r.Body}
+		return data[:max], false, nil
+	}
+	r.Body.Close()
+	r.ContentLength = int64(len(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return data, true, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bodies returns a Client that fails its first failures requests with
+// errFlaky, after reading them, and stores the body of every request it gets
+// in got.
+func bodies(failures int, got *[]string) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		*got = append(*got, string(body))
+		if len(*got) <= failures {
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+}
+
+// unseekable returns a reader of s for which http.NewRequest sets no GetBody.
+func unseekable(s string) io.Reader {
+	return io.MultiReader(strings.NewReader(s))
+}
+
+func TestBufferRequestBody(t *testing.T) {
+	var got []string
+	c := Decorate(bodies(2, &got), FaultTolerance(2, 0), BufferRequestBody(16))
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable("payload"))).Body.Close()
+	if want := []string{"payload", "payload", "payload"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent bodies %q, want %q", got, want)
+	}
+
+	_, err := c.Do(newRequest(t, http.MethodPost, "http://example.com/", unseekable(strings.Repeat("x", 17))))
+	if !errors.Is(err, ErrRequestTooLarge) {
+		t.Fatalf("Do() error = %v, want %v", err, ErrRequestTooLarge)
+	}
+}
+
+func TestCaptureBodyOnError(t *testing.T) {
+	var sent []string
+	status := http.StatusOK
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		sent = append(sent, string(body))
+		if r.URL.Path == "/err" {
+			return nil, errFlaky
+		}
+		res := newResponse(r, status, "")
+		res.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		return res, nil
+	})
+	var captured strings.Builder
+	c := Decorate(next, CaptureBodyOnError(&captured))
+
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/ok", unseekable("fine"))).Body.Close()
+	if captured.Len() != 0 {
+		t.Fatalf("captured %q for a success, want nothing", captured.String())
+	}
+
+	status = http.StatusBadGateway
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/bad", unseekable("broken"))).Body.Close()
+	if _, err := c.Do(newRequest(t, http.MethodPost, "http://example.com/err", unseekable("lost"))); err != errFlaky {
+		t.Fatalf("Do() error = %v, want %v", err, errFlaky)
+	}
+	want := "POST http://example.com/bad (502 Bad Gateway)\nbroken\nPOST http://example.com/err (error: " + errFlaky.Error() + ")\nlost\n"
+	if got := captured.String(); got != want {
+		t.Fatalf("captured %q, want %q", got, want)
+	}
+	if want := []string{"fine", "broken", "lost"}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent bodies %q, want %q", sent, want)
+	}
+}
+
+func TestCaptureBodyOnErrorLimit(t *testing.T) {
+	var sent int
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			return nil, err
+		}
+		sent = int(n)
+		return newResponse(r, http.StatusBadGateway, ""), nil
+	})
+	var captured strings.Builder
+	c := Decorate(next, CaptureBodyOnError(&captured))
+
+	body := strings.Repeat("z", maxBufferedBody+1)
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable(body))).Body.Close()
+	if sent != len(body) {
+		t.Fatalf("sent %d bytes, want %d", sent, len(body))
+	}
+	if got := strings.Count(captured.String(), "z"); got != maxBufferedBody {
+		t.Fatalf("captured %d bytes of the body, want %d", got, maxBufferedBody)
+	}
+}
+
+func TestRetryUploads(t *testing.T) {
+	var sent []string
+	failures := 2
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if failures > 0 {
+			failures--
+			buf := make([]byte, 3)
+			io.ReadFull(r.Body, buf)
+			return nil, errFlaky // the connection broke mid-upload
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		sent = append(sent, string(body))
+		if r.URL.Path == "/after" {
+			return nil, errFlaky // the body was sent, the response was lost
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	c := Decorate(next, RetryUploads(3, 0, 16))
+
+	do(t, c, newRequest(t, http.MethodPut, "http://example.com/", unseekable("payload"))).Body.Close()
+	if want := []string{"payload"}; !reflect.DeepEqual(sent, want) || failures != 0 {
+		t.Fatalf("sent bodies %q with %d failures left, want %q after both failures", sent, failures, want)
+	}
+
+	sent = nil
+	if _, err := c.Do(newRequest(t, http.MethodPut, "http://example.com/after", unseekable("payload"))); err != errFlaky || len(sent) != 1 {
+		t.Fatalf("Do() error = %v after %d uploads, want %v after 1", err, len(sent), errFlaky)
+	}
+
+	if _, err := c.Do(newRequest(t, http.MethodPut, "http://example.com/", unseekable(strings.Repeat("x", 17)))); !errors.Is(err, ErrRequestTooLarge) {
+		t.Fatalf("large body: Do() error = %v, want %v", err, ErrRequestTooLarge)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	var reports []string
+	progress := func(sent, total int64) { reports = append(reports, fmt.Sprintf("%d/%d", sent, total)) }
+	var calls int
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		buf := make([]byte, 4)
+		for {
+			if _, err := r.Body.Read(buf); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if r.URL.Path == "/flaky" && calls == 1 {
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	c := Decorate(next, UploadProgress(progress))
+
+	do(t, c, newRequest(t, http.MethodPut, "http://example.com/", unseekable("payload"))).Body.Close()
+	if want := []string{"4/-1", "7/-1"}; !reflect.DeepEqual(reports, want) {
+		t.Fatalf("unknown length: reported %v, want %v", reports, want)
+	}
+
+	reports, calls = nil, 0
+	c = Decorate(next, FaultTolerance(1, 0), UploadProgress(progress))
+	do(t, c, newRequest(t, http.MethodPut, "http://example.com/flaky", strings.NewReader("payload"))).Body.Close()
+	if want := []string{"4/7", "7/7", "4/7", "7/7"}; !reflect.DeepEqual(reports, want) {
+		t.Fatalf("resent body: reported %v, want %v", reports, want)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker Decorator for the requests it
+// rejects while open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker returns a Decorator that stops sending a Client's requests
+// after threshold consecutive failures, i.e. errors or 5xx responses, and
+// rejects them with ErrCircuitOpen instead. Once cooldown has passed, a
+// single trial request is let through: the breaker closes again if it
+// succeeds and stays open for another cooldown if it fails.
+//
+// A breaker wrapping retrying Decorators, i.e. coming after them in Decorate,
+// sees each request once, however many attempts it takes, and the retries of
+// a request stop with ErrCircuitOpen as soon as the breaker opens.
+//
+// The returned Client is a HealthReporter that is unhealthy while open.
+func CircuitBreaker(threshold int, cooldown time.Duration) Decorator {
+	return CircuitBreakerWithEvents(threshold, cooldown, BreakerEvents{})
+}
+
+// CircuitBreakerWithEvents returns a Decorator like CircuitBreaker that
+// reports what the breaker does to the given BreakerEvents.
+func CircuitBreakerWithEvents(threshold int, cooldown time.Duration, events BreakerEvents) Decorator {
+	return func(c Client) Client {
+		return &circuitBreaker{client: c, threshold: threshold, cooldown: cooldown, events: events}
+	}
+}
+
+// BreakerEvents receives the events of a circuit breaker. Any field may be nil.
+type BreakerEvents struct {
+	// OnStateChange is called, outside of the breaker's lock, on every transition.
+	OnStateChange func(from, to BreakerState)
+	// Trips counts the transitions to BreakerOpen.
+	Trips Counter
+	// Rejections counts the requests rejected with ErrCircuitOpen.
+	Rejections Counter
+}
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every request until its cooldown has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker is the Client returned by a CircuitBreaker Decorator.
+type circuitBreaker struct {
+	client    Client
+	threshold int
+	cooldown  time.Duration
+	events    BreakerEvents
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// Do sends r unless the breaker is open, and records its outcome.
+func (b *circuitBreaker) Do(r *http.Request) (*http.Response, error) {
+	clock := clockFrom(r.Context())
+	if err := b.allow(clock.Now()); err != nil {
+		if b.events.Rejections != nil {
+			b.events.Rejections.Add(1)
+		}
+		return nil, err
+	}
+	res, err := b.client.Do(r.WithContext(context.WithValue(r.Context(), breakerKey, b)))
+	b.record(err != nil || res.StatusCode >= 500, clock.Now())
+	return res, err
+}
+
+// allow reports whether a request may be sent at the given time.
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	from := b.state
+	err := b.allowLocked(now)
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return err
+}
+
+func (b *circuitBreaker) allowLocked(now time.Time) error {
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// open reports whether the breaker rejects requests at the given time.
+func (b *circuitBreaker) open(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen && now.Sub(b.openedAt) < b.cooldown
+}
+
+// record updates the breaker with the outcome of a request sent at the given time.
+func (b *circuitBreaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	from := b.state
+	b.recordLocked(failed, now)
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *circuitBreaker) recordLocked(failed bool, now time.Time) {
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		if b.failures++; b.failures >= b.threshold {
+			b.state, b.openedAt = BreakerOpen, now
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+		if failed {
+			b.state, b.openedAt = BreakerOpen, now
+		} else {
+			b.state, b.failures = BreakerClosed, 0
+		}
+	}
+}
+
+// notify reports the transition between the given states, if any.
+func (b *circuitBreaker) notify(from, to BreakerState) {
+	if from == to {
+		return
+	}
+	if to == BreakerOpen && b.events.Trips != nil {
+		b.events.Trips.Add(1)
+	}
+	if b.events.OnStateChange != nil {
+		b.events.OnStateChange(from, to)
+	}
+}
+
+// Unwrap returns the Client that b decorates.
+func (b *circuitBreaker) Unwrap() Client {
+	return b.client
+}
+
+// Health returns ErrCircuitOpen while the breaker isn't closed.
+func (b *circuitBreaker) Health() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		return ErrCircuitOpen
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	c := Decorate(flaky(3, &calls), CircuitBreaker(2, time.Minute), WithClock(clock))
+	send := func() error {
+		_, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+		return err
+	}
+
+	send()
+	send()
+	if err := send(); !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Fatalf("after 2 failures: Do() error = %v after %d calls, want %v after 2", err, calls, ErrCircuitOpen)
+	}
+	if err := ChainHealth(c); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open: ChainHealth() = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// The trial request fails, which keeps the breaker open.
+	clock.Advance(time.Minute)
+	if err := send(); err != errFlaky {
+		t.Fatalf("trial: Do() error = %v, want %v", err, errFlaky)
+	}
+	if err := send(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after failed trial: Do() error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	clock.Advance(time.Minute)
+	if err := send(); err != nil {
+		t.Fatalf("second trial: Do() error = %v, want nil", err)
+	}
+	if err := ChainHealth(c); err != nil {
+		t.Fatalf("closed: ChainHealth() = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerWithEvents(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	var transitions []string
+	events := BreakerEvents{
+		OnStateChange: func(from, to BreakerState) { transitions = append(transitions, from.String()+" > "+to.String()) },
+		Trips:         NewCounter("trips"),
+		Rejections:    NewCounter("rejections"),
+	}
+	c := Decorate(flaky(1, &calls), CircuitBreakerWithEvents(1, time.Minute, events), WithClock(clock))
+	for i := 0; i < 3; i++ {
+		c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	}
+	clock.Advance(time.Minute)
+	c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+
+	want := []string{"closed > open", "open > half-open", "half-open > closed"}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	if trips, rejections := events.Trips.(*AtomicCounter).Value(), events.Rejections.(*AtomicCounter).Value(); trips != 1 || rejections != 2 {
+		t.Fatalf("trips = %d and rejections = %d, want 1 and 2", trips, rejections)
+	}
+}
+
+func TestCircuitBreakerStopsRetries(t *testing.T) {
+	var c Client
+	calls := map[string]int{}
+	c = Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls[r.URL.Path]++
+		if r.URL.Path == "/b" && calls["/b"] == 1 {
+			// Another request exhausts its retries, opening the breaker,
+			// while this one is in flight.
+			if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/a", nil)); err != errFlaky {
+				t.Errorf("Do(/a) error = %v, want %v", err, errFlaky)
+			}
+		}
+		return nil, errFlaky
+	}), FaultToleranceIdempotent(3, time.Second), CircuitBreaker(1, time.Minute), WithClock(newFakeClock()))
+
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/b", nil))
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Do(/b) error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if calls["/a"] != 4 || calls["/b"] != 1 {
+		t.Fatalf("sent /a %d times and /b %d times, want 4 and 1", calls["/a"], calls["/b"])
+	}
+}
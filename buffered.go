@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// BufferedCounter is a Counter that buffers increments and adds them to an
+// underlying Counter in a single call, every interval or once size increments
+// are buffered, whichever comes first. Close flushes what's left.
+//
+// A BufferedCounter flushes from a goroutine of its own, which runs until
+// Close is called, so Close must be called once it's no longer used.
+type BufferedCounter struct {
+	counter Counter
+	size    int
+	ticker  *flushTicker
+
+	mu      sync.Mutex
+	pending uint64
+	adds    int
+}
+
+// NewBufferedCounter returns a BufferedCounter that flushes into c. A
+// non-positive interval disables the periodic flushes.
+func NewBufferedCounter(c Counter, size int, interval time.Duration) *BufferedCounter {
+	b := &BufferedCounter{counter: c, size: size}
+	b.ticker = newFlushTicker(interval, b.Flush)
+	return b
+}
+
+// Add buffers an increment by delta, flushing the buffer if it's full.
+func (b *BufferedCounter) Add(delta uint64) {
+	b.mu.Lock()
+	b.pending += delta
+	b.adds++
+	full := b.adds >= b.size
+	b.mu.Unlock()
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush adds the buffered increments to the underlying Counter.
+func (b *BufferedCounter) Flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending, b.adds = 0, 0
+	b.mu.Unlock()
+	if pending > 0 {
+		b.counter.Add(pending)
+	}
+}
+
+// Close stops the periodic flushes and flushes the buffer one last time.
+func (b *BufferedCounter) Close() error {
+	b.ticker.stop()
+	b.Flush()
+	return nil
+}
+
+// BufferedHistogram is a Histogram that buffers observations and passes them
+// on to an underlying Histogram every interval or once size observations are
+// buffered, whichever comes first. Close flushes what's left.
+//
+// Like a BufferedCounter, a BufferedHistogram must be closed once it's no
+// longer used.
+type BufferedHistogram struct {
+	histogram Histogram
+	size      int
+	ticker    *flushTicker
+
+	mu      sync.Mutex
+	pending []int64
+}
+
+// NewBufferedHistogram returns a BufferedHistogram that flushes into h. A
+// non-positive interval disables the periodic flushes.
+func NewBufferedHistogram(h Histogram, size int, interval time.Duration) *BufferedHistogram {
+	b := &BufferedHistogram{histogram: h, size: size}
+	b.ticker = newFlushTicker(interval, b.Flush)
+	return b
+}
+
+// Observe buffers value, flushing the buffer if it's full.
+func (b *BufferedHistogram) Observe(value int64) {
+	b.mu.Lock()
+	b.pending = append(b.pending, value)
+	full := len(b.pending) >= b.size
+	b.mu.Unlock()
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush passes the buffered observations on to the underlying Histogram.
+func (b *BufferedHistogram) Flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	for _, value := range pending {
+		b.histogram.Observe(value)
+	}
+}
+
+// Close stops the periodic flushes and flushes the buffer one last time.
+func (b *BufferedHistogram) Close() error {
+	b.ticker.stop()
+	b.Flush()
+	return nil
+}
+
+// flushTicker calls a flush function periodically until stopped.
+type flushTicker struct {
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// newFlushTicker returns a flushTicker calling flush every interval, or a
+// stopped one if interval isn't positive.
+func newFlushTicker(interval time.Duration, flush func()) *flushTicker {
+	t := &flushTicker{done: make(chan struct{})}
+	if interval <= 0 {
+		return t
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// stop stops the ticker and waits for an ongoing flush to return.
+func (t *flushTicker) stop() {
+	t.once.Do(func() { close(t.done) })
+	t.wg.Wait()
+}
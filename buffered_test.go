@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBufferedCounter(t *testing.T) {
+	c := NewCounter("requests")
+	b := NewBufferedCounter(c, 3, 0)
+	b.Add(1)
+	b.Add(2)
+	if got := c.Value(); got != 0 {
+		t.Fatalf("before size adds: Value() = %d, want 0", got)
+	}
+	b.Add(3)
+	if got := c.Value(); got != 6 {
+		t.Fatalf("after size adds: Value() = %d, want 6", got)
+	}
+	b.Add(4)
+	b.Close()
+	if got := c.Value(); got != 10 {
+		t.Fatalf("after Close: Value() = %d, want 10", got)
+	}
+}
+
+func TestBufferedCounterFlushesPeriodically(t *testing.T) {
+	c := NewCounter("requests")
+	b := NewBufferedCounter(c, 100, time.Millisecond)
+	defer b.Close()
+	b.Add(1)
+	for deadline := time.Now().Add(time.Second); c.Value() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the buffer was never flushed")
+		}
+	}
+}
+
+func TestBufferedHistogram(t *testing.T) {
+	h := NewHistogram("latency", 0, 100, 0, 100)
+	b := NewBufferedHistogram(h, 2, -time.Second)
+	b.Observe(10)
+	if _, count, _ := h.summary(); count != 0 {
+		t.Fatalf("before size observations: count = %d, want 0", count)
+	}
+	b.Observe(20)
+	b.Observe(30)
+	b.Close()
+	if _, count, sum := h.summary(); count != 3 || sum != 60 {
+		t.Fatalf("after Close: count = %d and sum = %d, want 3 and 60", count, sum)
+	}
+}
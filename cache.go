@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatusHeader is the response header in which a Cache's Decorator
+// tells whether a response was a cache "HIT", "MISS" or "STALE" hit.
+const CacheStatusHeader = "X-Cache"
+
+// A Cache keeps the successful responses to GET requests in memory and serves
+// them again while they're fresh. When the upstream fails, with an error or a
+// 5xx response, a stale response is served instead for a while.
+type Cache struct {
+	ttl          time.Duration
+	staleIfError time.Duration
+	// keyParams, when set, are the only query parameters in the keys.
+	keyParams map[string]bool
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	swept   time.Time
+}
+
+// cacheEntry is a response stored in a Cache.
+type cacheEntry struct {
+	res    *sharedResponse
+	stored time.Time
+	// release gives the memory of res back to its MemoryBudget.
+	release func()
+}
+
+// NewCache returns a Cache whose responses stay fresh for ttl and can still be
+// served for staleIfError after that when the upstream fails.
+func NewCache(ttl, staleIfError time.Duration) *Cache {
+	return &Cache{ttl: ttl, staleIfError: staleIfError, entries: map[string]*cacheEntry{}}
+}
+
+// KeyOnQuery makes c key responses on only the given query parameters of
+// request URLs, in sorted order, so that URLs differing only in other
+// parameters, like cache busters, or in the order of parameters share an
+// entry. It returns c, and must be called before c's Decorator is in use.
+func (c *Cache) KeyOnQuery(params ...string) *Cache {
+	c.keyParams = make(map[string]bool, len(params))
+	for _, p := range params {
+		c.keyParams[p] = true
+	}
+	return c
+}
+
+// Decorator returns a Decorator that serves a Client's GET requests from c.
+// Responses are stored unless they, or the request, are marked no-store, or
+// they don't fit in the MemoryBudget in the request context, if any, and
+// streaming requests bypass the cache, as do requests carrying Authorization,
+// so that responses never leak from one user to another. Requests marked
+// no-cache are sent even if a fresh response is stored, and their response
+// replaces it. Responses are dropped once too old to be served even stale.
+func (c *Cache) Decorator() Decorator {
+	return func(next Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Method != http.MethodGet || hasDirective(r.Header, "no-store") || IsStreaming(r) ||
+				r.Header.Get("Authorization") != "" {
+				return next.Do(r)
+			}
+			key := c.key(r.Method, r.URL)
+			clock := clockFrom(r.Context())
+			entry := c.lookup(key)
+			if entry != nil && clock.Now().Sub(entry.stored) < c.ttl && !hasDirective(r.Header, "no-cache") {
+				AddSpanEvent(r.Context(), "cache.hit", nil)
+				return cached(entry, "HIT"), nil
+			}
+
+			res, err := next.Do(r)
+			if err != nil || res.StatusCode >= 500 {
+				if entry != nil && clock.Now().Sub(entry.stored) < c.ttl+c.staleIfError {
+					if res != nil {
+						drainAndClose(res.Body)
+					}
+					AddSpanEvent(r.Context(), "cache.stale", nil)
+					return cached(entry, "STALE"), nil
+				}
+				return res, err
+			}
+			if res.StatusCode != http.StatusOK || hasDirective(res.Header, "no-store") {
+				return res, nil
+			}
+
+			shared, release, err := bufferResponse(r.Context(), res)
+			if err != nil {
+				return nil, err
+			}
+			if shared == nil {
+				res.Header.Set(CacheStatusHeader, "MISS")
+				return res, nil
+			}
+			c.store(key, &cacheEntry{res: shared, stored: clock.Now(), release: release}, clock.Now())
+			res = shared.response()
+			res.Header.Set(CacheStatusHeader, "MISS")
+			return res, nil
+		})
+	}
+}
+
+// Purge discards the response stored for the given method and URL, if any,
+// e.g. after a request known to have changed the resource.
+func (c *Cache) Purge(method, rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	key := c.key(method, u)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.release()
+		delete(c.entries, key)
+	}
+}
+
+// PurgeAll discards every stored response.
+func (c *Cache) PurgeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		entry.release()
+	}
+	c.entries = map[string]*cacheEntry{}
+}
+
+// key returns the key under which the response to a request with the given
+// method and URL is stored.
+func (c *Cache) key(method string, u *url.URL) string {
+	if c.keyParams == nil {
+		return method + " " + u.String()
+	}
+	query := url.Values{}
+	for name, values := range u.Query() {
+		if c.keyParams[name] {
+			query[name] = values
+		}
+	}
+	filtered := *u
+	filtered.RawQuery = query.Encode()
+	return method + " " + filtered.String()
+}
+
+func (c *Cache) lookup(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+// store stores entry under key. Once ttl has passed since the last sweep, it
+// first drops the entries too old to be served.
+func (c *Cache) store(key string, entry *cacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.swept) >= c.ttl {
+		for k, e := range c.entries {
+			if now.Sub(e.stored) >= c.ttl+c.staleIfError {
+				e.release()
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+	if old, ok := c.entries[key]; ok {
+		old.release()
+	}
+	c.entries[key] = entry
+}
+
+// cached returns a copy of the response stored in entry, marked with status.
+func cached(entry *cacheEntry, status string) *http.Response {
+	res := entry.res.response()
+	res.Header.Set(CacheStatusHeader, status)
+	return res
+}
+
+// hasDirective reports whether the Cache-Control header in h has the given
+// directive.
+func hasDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// counting returns a Client that answers every request with a 200 whose body
+// is the number of requests it got so far, or with a 503 while failing is set.
+func counting(failing *bool) Client {
+	var calls int
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if failing != nil && *failing {
+			return newResponse(r, http.StatusServiceUnavailable, ""), nil
+		}
+		calls++
+		return newResponse(r, http.StatusOK, strconv.Itoa(calls)), nil
+	})
+}
+
+// get sends a GET for url through c and returns the response body and its
+// CacheStatusHeader.
+func get(t *testing.T, c Client, url string, header ...string) (string, string) {
+	t.Helper()
+	r := newRequest(t, http.MethodGet, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	res := do(t, c, r)
+	return bodyString(t, res), res.Header.Get(CacheStatusHeader)
+}
+
+func TestCache(t *testing.T) {
+	clock := newFakeClock()
+	var failing bool
+	c := Decorate(counting(&failing), NewCache(time.Minute, time.Hour).Decorator(), WithClock(clock))
+
+	for _, want := range [][2]string{{"1", "MISS"}, {"1", "HIT"}} {
+		if body, status := get(t, c, "http://example.com/"); body != want[0] || status != want[1] {
+			t.Fatalf("got %s (%s), want %s (%s)", body, status, want[0], want[1])
+		}
+	}
+	clock.Advance(time.Minute)
+	if body, status := get(t, c, "http://example.com/"); body != "2" || status != "MISS" {
+		t.Fatalf("after ttl: got %s (%s), want 2 (MISS)", body, status)
+	}
+
+	clock.Advance(30 * time.Minute)
+	failing = true
+	if body, status := get(t, c, "http://example.com/"); body != "2" || status != "STALE" {
+		t.Fatalf("upstream failing: got %s (%s), want 2 (STALE)", body, status)
+	}
+}
+
+func TestCacheBypassesAuthorizedRequests(t *testing.T) {
+	c := Decorate(counting(nil), NewCache(time.Minute, 0).Decorator())
+	get(t, c, "http://example.com/")
+	for _, want := range []string{"2", "3"} {
+		if body, status := get(t, c, "http://example.com/", "Authorization", "Bearer alice"); body != want || status != "" {
+			t.Fatalf("authorized: got %s (%s), want %s from upstream", body, status, want)
+		}
+	}
+}
+
+func TestCacheDropsExpiredEntries(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(time.Minute, time.Minute)
+	c := Decorate(counting(nil), cache.Decorator(), WithClock(clock))
+	get(t, c, "http://example.com/a")
+	get(t, c, "http://example.com/b")
+	clock.Advance(2 * time.Minute)
+	get(t, c, "http://example.com/c")
+	if len(cache.entries) != 1 {
+		t.Fatalf("kept %d entries, want only the fresh one", len(cache.entries))
+	}
+}
+
+func TestCacheKeyOnQuery(t *testing.T) {
+	c := Decorate(counting(nil), NewCache(time.Minute, 0).KeyOnQuery("page", "sort").Decorator())
+	for _, tc := range [][2]string{
+		{"http://example.com/items?page=1&sort=name", "1"},
+		{"http://example.com/items?sort=name&page=1&_=1700000", "1"},
+		{"http://example.com/items?page=2&sort=name", "2"},
+		{"http://example.com/items?page=1", "3"},
+		{"http://example.com/items?page=1&_=1700001", "3"},
+		{"http://example.com/other?page=1", "4"},
+	} {
+		if body, _ := get(t, c, tc[0]); body != tc[1] {
+			t.Errorf("%s: got response %s, want %s", tc[0], body, tc[1])
+		}
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	cache := NewCache(time.Minute, 0).KeyOnQuery("id")
+	c := Decorate(counting(nil), cache.Decorator())
+	get(t, c, "http://example.com/a?id=1")
+	get(t, c, "http://example.com/b")
+
+	cache.Purge(http.MethodGet, "http://example.com/a?id=1&_=2")
+	if body, status := get(t, c, "http://example.com/a?id=1"); body != "3" || status != "MISS" {
+		t.Fatalf("after Purge: got %s (%s), want 3 (MISS)", body, status)
+	}
+	if body, status := get(t, c, "http://example.com/b"); body != "2" || status != "HIT" {
+		t.Fatalf("other entry after Purge: got %s (%s), want 2 (HIT)", body, status)
+	}
+
+	cache.PurgeAll()
+	if body, _ := get(t, c, "http://example.com/b"); body != "4" {
+		t.Fatalf("after PurgeAll: got %s, want a fresh response", body)
+	}
+}
+
+func TestCacheNoCache(t *testing.T) {
+	c := Decorate(counting(nil), NewCache(time.Minute, 0).Decorator())
+	get(t, c, "http://example.com/")
+	if body, status := get(t, c, "http://example.com/", "Cache-Control", "no-cache"); body != "2" || status != "MISS" {
+		t.Fatalf("no-cache request got %s (%s), want a fresh 2 (MISS)", body, status)
+	}
+	if body, status := get(t, c, "http://example.com/"); body != "2" || status != "HIT" {
+		t.Fatalf("next request got %s (%s), want the refreshed 2 (HIT)", body, status)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// A Clock tells the time and waits for it to pass. Decorators that wait, like
+// the FaultTolerance ones, use the Clock of the request context.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock returns a Decorator that makes the Decorators it wraps use the
+// given Clock instead of the real one, e.g. to test backoff schedules without
+// waiting.
+func WithClock(clock Clock) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return c.Do(r.WithContext(context.WithValue(r.Context(), clockKey, clock)))
+		})
+	}
+}
+
+// clockFrom returns the Clock of ctx, or the real one if it has none.
+func clockFrom(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockKey).(Clock); ok {
+		return clock
+	}
+	return realClock{}
+}
+
+// sleep pauses for d or until ctx is done, in which case it returns the
+// context error.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-clockFrom(ctx).After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
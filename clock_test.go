@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithClock(t *testing.T) {
+	clock := newFakeClock()
+	var got Clock
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got = clockFrom(r.Context())
+		return newResponse(r, http.StatusOK, ""), nil
+	}), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if got != clock {
+		t.Fatalf("clockFrom() = %v, want the Clock of WithClock", got)
+	}
+	if _, ok := clockFrom(context.Background()).(realClock); !ok {
+		t.Fatal("clockFrom() without a Clock isn't the real one")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	clock := newFakeClock()
+	ctx := context.WithValue(context.Background(), clockKey, clock)
+	if err := sleep(ctx, time.Minute); err != nil {
+		t.Fatalf("sleep() = %v, want nil", err)
+	}
+	if got, want := clock.Waits(), []time.Duration{time.Minute}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("sleep() after cancel = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalesceByIdempotencyKey returns a Decorator that collapses concurrent
+// requests with the same method, URL, IdempotencyKeyHeader and credentials,
+// in the Authorization, Proxy-Authorization and Cookie headers, into a single
+// upstream call whose result every caller receives, each with its own copy of
+// the response body, or the same error. Requests without the header are sent
+// as they are, and callers whose context is done stop waiting. Body buffering is subject to the MemoryBudget in the request
+// context, if any: when a body doesn't fit, the caller whose request was sent
+// gets the response streamed and the others send their own.
+func CoalesceByIdempotencyKey() Decorator {
+	return CoalesceByIdempotencyKeyWith(true)
+}
+
+// CoalesceByIdempotencyKeyWith returns a Decorator like
+// CoalesceByIdempotencyKey that, unless shareErrors is true, doesn't share
+// errors: when the upstream call fails, the callers that were waiting for it
+// send their own requests instead.
+func CoalesceByIdempotencyKeyWith(shareErrors bool) Decorator {
+	return func(c Client) Client {
+		g := &flightGroup{ownErrors: !shareErrors}
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || IsStreaming(r) {
+				return c.Do(r)
+			}
+			key = r.Method + " " + r.URL.String() + " " + key + headerKey(r.Header, credentialHeaders)
+			return g.do(r.Context(), key, func() (*http.Response, error) {
+				return c.Do(r)
+			})
+		})
+	}
+}
+
+// CoalesceByBody returns a Decorator like CoalesceByIdempotencyKey that
+// collapses concurrent requests with the same method, URL and body, so that a
+// stampede of identical POSTs makes a single upstream call. Requests are only
+// collapsed if they also have the same credentials, in the Authorization,
+// Proxy-Authorization and Cookie headers, and the same values of the given
+// headers, so that no caller gets a response meant for another. Bodies are
+// hashed from a copy when GetBody is set, and buffered otherwise; requests
+// whose body is longer than maxBody bytes are sent as they are.
+func CoalesceByBody(maxBody int64, headers ...string) Decorator {
+	headers = append(append([]string(nil), credentialHeaders...), headers...)
+	return func(c Client) Client {
+		g := &flightGroup{}
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if IsStreaming(r) {
+				return c.Do(r)
+			}
+			sum, ok, err := bodyHash(r, maxBody)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return c.Do(r)
+			}
+			key := r.Method + " " + r.URL.String() + " " + sum + headerKey(r.Header, headers)
+			return g.do(r.Context(), key, func() (*http.Response, error) {
+				return c.Do(r)
+			})
+		})
+	}
+}
+
+// credentialHeaders are the request headers carrying credentials, on which
+// the Decorators sharing responses between requests key them.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// headerKey serializes the values of the given headers of h for a key.
+func headerKey(h http.Header, names []string) string {
+	var key strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&key, " %q", h.Values(name))
+	}
+	return key.String()
+}
+
+// bodyHash returns the hex-encoded SHA-256 of the body of r, and false
+// instead if the body is longer than max bytes. It leaves r with a body that
+// reads the same in full.
+func bodyHash(r *http.Request, max int64) (string, bool, error) {
+	h := sha256.New()
+	switch {
+	case r.Body == nil || r.Body == http.NoBody:
+	case r.GetBody != nil:
+		body, err := r.GetBody()
+		if err != nil {
+			return "", false, err
+		}
+		defer body.Close()
+		n, err := io.Copy(h, io.LimitReader(body, max+1))
+		if err != nil {
+			return "", false, err
+		}
+		if n > max {
+			return "", false, nil
+		}
+	default:
+		prefix, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+		if err != nil {
+			return "", false, err
+		}
+		if int64(len(prefix)) > max {
+			r.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(prefix), r.Body), Closer: r.Body}
+			return "", false, nil
+		}
+		r.Body.Close()
+		r.ContentLength = int64(len(prefix))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(prefix)), nil
+		}
+		r.Body, _ = r.GetBody()
+		h.Write(prefix)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+// ErrDuplicate is returned by a Debounce Decorator for requests repeating
+// one sent shortly before.
+var ErrDuplicate = errors.New("duplicate request")
+
+// Debounce returns a Decorator that fails with ErrDuplicate every request
+// whose key matches that of a request sent less than window ago, without
+// calling the Client. Requests with an empty key are always sent.
+func Debounce(key func(*http.Request) string, window time.Duration) Decorator {
+	return func(c Client) Client {
+		var (
+			mu   sync.Mutex
+			sent = map[string]time.Time{}
+		)
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			k := key(r)
+			if k == "" {
+				return c.Do(r)
+			}
+			now := clockFrom(r.Context()).Now()
+			mu.Lock()
+			for k, at := range sent {
+				if now.Sub(at) >= window {
+					delete(sent, k)
+				}
+			}
+			if _, ok := sent[k]; ok {
+				mu.Unlock()
+				return nil, fmt.Errorf("%w: %q within %s", ErrDuplicate, k, window)
+			}
+			sent[k] = now
+			mu.Unlock()
+			return c.Do(r)
+		})
+	}
+}
+
+// flightGroup collapses concurrent calls with the same key into one.
+type flightGroup struct {
+	// ownErrors, when set, makes waiting callers call their own fn when the
+	// call they waited for fails, instead of getting its error.
+	ownErrors bool
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a call in progress or completed within a flightGroup.
+type flight struct {
+	done chan struct{}
+	res  *sharedResponse
+	err  error
+	// unshared is set when the response didn't fit in the MemoryBudget.
+	unshared bool
+	// waiters is the number of callers waiting for the call. g.mu must be
+	// held.
+	waiters int
+	// copies is the number of copies of res yet to be closed, and release
+	// gives the memory of res back to its MemoryBudget once none are left.
+	copies  atomic.Int64
+	release func()
+}
+
+// do calls fn, unless a call with the same key is already in progress, in
+// which case it waits for that call instead. Every caller gets a copy of the
+// resulting response, unless its body doesn't fit in the MemoryBudget of ctx,
+// in which case the waiting callers call their own fn. The body is held
+// within the budget until every copy is closed. Waiting callers return the
+// error of ctx once it is done.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = map[string]*flight{}
+	}
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return g.leave(key, f, ctx.Err())
+		}
+		if f.unshared || f.err != nil && g.ownErrors {
+			return fn()
+		}
+		return f.result()
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	res, err := fn()
+	if err == nil {
+		f.res, f.release, f.err = bufferResponse(ctx, res)
+		f.unshared = f.res == nil && f.err == nil
+	} else {
+		f.err = err
+	}
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	f.copies.Store(int64(f.waiters) + 1)
+	g.mu.Unlock()
+	close(f.done)
+	if f.unshared {
+		return res, nil
+	}
+	return f.result()
+}
+
+// leave makes a caller waiting for f stop waiting and returns err. If f
+// completed meanwhile, counting the caller among those getting a copy, the
+// copy is closed right away.
+func (g *flightGroup) leave(key string, f *flight, err error) (*http.Response, error) {
+	g.mu.Lock()
+	waiting := g.flights[key] == f
+	if waiting {
+		f.waiters--
+	}
+	g.mu.Unlock()
+	if !waiting {
+		<-f.done
+		if !f.unshared && f.err == nil {
+			res, _ := f.result()
+			res.Body.Close()
+		}
+	}
+	return nil, err
+}
+
+// result returns a copy of the response of f, whose body gives the memory of
+// the response back to its MemoryBudget when it is the last copy closed.
+func (f *flight) result() (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	res := f.res.response()
+	res.Body = &flightBody{ReadCloser: res.Body, f: f}
+	return res, nil
+}
+
+// flightBody is the body of a copy of the response of a flight.
+type flightBody struct {
+	io.ReadCloser
+	f      *flight
+	closed atomic.Bool
+}
+
+func (b *flightBody) Close() error {
+	if b.closed.CompareAndSwap(false, true) && b.f.copies.Add(-1) == 0 {
+		b.f.release()
+	}
+	return b.ReadCloser.Close()
+}
+
+// sharedResponse is a response with a buffered body from which any number of
+// copies can be made.
+type sharedResponse struct {
+	res  *http.Response
+	body []byte
+}
+
+// shareResponse reads and closes the body of res to make it shareable.
+func shareResponse(res *http.Response) (*sharedResponse, error) {
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &sharedResponse{res: res, body: body}, nil
+}
+
+// response returns a copy of the shared response with its own body reader.
+func (s *sharedResponse) response() *http.Response {
+	res := *s.res
+	res.Header = s.res.Header.Clone()
+	res.Body = io.NopCloser(bytes.NewReader(s.body))
+	return &res
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gate returns a Client that signals on entered, unless a signal is pending
+// already, when it gets a request and answers it with a 200 whose body is body
+// once release is closed. It counts the requests it gets in calls.
+func gate(body string, calls *atomic.Int32, entered chan<- struct{}, release <-chan struct{}) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return newResponse(r, http.StatusOK, body), nil
+	})
+}
+
+// concurrently sends the requests made by newRequest through c, n at a time:
+// one first, and the others once the Client got it. It returns the bodies of
+// the responses.
+func concurrently(t *testing.T, c Client, n int, newRequest func(i int) *http.Request, entered <-chan struct{}, release chan<- struct{}) []string {
+	t.Helper()
+	bodies := make([]string, n)
+	var wg sync.WaitGroup
+	send := func(i int) {
+		defer wg.Done()
+		res, err := c.Do(newRequest(i))
+		if err != nil {
+			t.Errorf("request %d: %v", i, err)
+			return
+		}
+		bodies[i] = bodyString(t, res)
+	}
+	wg.Add(n)
+	go send(0)
+	<-entered
+	for i := 1; i < n; i++ {
+		go send(i)
+	}
+	// Give the others the time to join the first request, and let through
+	// those that don't.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return bodies
+}
+
+func TestCoalesceByIdempotencyKey(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	c := Decorate(gate("shared", &calls, entered, release), CoalesceByIdempotencyKey())
+	bodies := concurrently(t, c, 4, func(i int) *http.Request {
+		r := newRequest(t, http.MethodPost, "http://example.com/", nil)
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		return r
+	}, entered, release)
+	if calls.Load() != 1 {
+		t.Fatalf("made %d upstream calls, want 1", calls.Load())
+	}
+	for i, body := range bodies {
+		if body != "shared" {
+			t.Errorf("caller %d got body %q, want %q", i, body, "shared")
+		}
+	}
+}
+
+func TestCoalesceByIdempotencyKeyWithoutKey(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	c := Decorate(gate("own", &calls, entered, release), CoalesceByIdempotencyKey())
+	concurrently(t, c, 3, func(i int) *http.Request {
+		return newRequest(t, http.MethodPost, "http://example.com/", nil)
+	}, entered, release)
+	if calls.Load() != 3 {
+		t.Fatalf("made %d upstream calls, want 3", calls.Load())
+	}
+}
+
+func TestCoalesceByIdempotencyKeyCredentials(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	c := Decorate(gate("own", &calls, entered, release), CoalesceByIdempotencyKey())
+	concurrently(t, c, 3, func(i int) *http.Request {
+		r := newRequest(t, http.MethodPost, "http://example.com/", nil)
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		r.Header.Set("Authorization", "Bearer user-"+strconv.Itoa(i))
+		return r
+	}, entered, release)
+	if calls.Load() != 3 {
+		t.Fatalf("made %d upstream calls for 3 users, want 3", calls.Load())
+	}
+}
+
+func TestCoalesceByIdempotencyKeyCancelledWaiter(t *testing.T) {
+	budget := NewMemoryBudget(1 << 20)
+	var calls atomic.Int32
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	c := Decorate(gate("shared", &calls, entered, release), CoalesceByIdempotencyKey(), budget.Decorator())
+	newKeyed := func(ctx context.Context) *http.Request {
+		r := newRequest(t, http.MethodPost, "http://example.com/", nil).WithContext(ctx)
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		return r
+	}
+
+	first := make(chan *http.Response)
+	go func() {
+		res, err := c.Do(newKeyed(context.Background()))
+		if err != nil {
+			t.Error(err)
+		}
+		first <- res
+	}()
+	<-entered
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error)
+	go func() {
+		_, err := c.Do(newKeyed(ctx))
+		waited <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-waited:
+		if err != context.Canceled {
+			t.Fatalf("cancelled waiter got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled waiter still waiting")
+	}
+
+	close(release)
+	if res := <-first; res != nil {
+		bodyString(t, res)
+	}
+	if budget.InUse() != 0 {
+		t.Fatalf("InUse() = %d with every copy closed, want 0", budget.InUse())
+	}
+}
+
+func TestCoalesceByIdempotencyKeyWith(t *testing.T) {
+	for _, shareErrors := range []bool{true, false} {
+		var calls atomic.Int32
+		entered, release := make(chan struct{}), make(chan struct{})
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if calls.Add(1) > 1 {
+				return newResponse(r, http.StatusOK, ""), nil
+			}
+			close(entered)
+			<-release
+			return nil, errFlaky
+		}), CoalesceByIdempotencyKeyWith(shareErrors))
+
+		errs := make(chan error, 3)
+		send := func() {
+			r := newRequest(t, http.MethodPost, "http://example.com/", nil)
+			r.Header.Set(IdempotencyKeyHeader, "key-1")
+			res, err := c.Do(r)
+			if err == nil {
+				res.Body.Close()
+			}
+			errs <- err
+		}
+		go send()
+		<-entered
+		go send()
+		go send()
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		var failed int
+		for i := 0; i < 3; i++ {
+			if err := <-errs; err == errFlaky {
+				failed++
+			}
+		}
+		if want := map[bool]int{true: 3, false: 1}[shareErrors]; failed != want || int(calls.Load()) != 4-want {
+			t.Errorf("shareErrors %v: %d callers failed after %d calls, want %d after %d", shareErrors, failed, calls.Load(), want, 4-want)
+		}
+	}
+}
+
+func TestCoalesceByBody(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		auth  func(i int) string
+		body  string
+		calls int32
+	}{
+		{"same body and credentials", func(int) string { return "Bearer a" }, "{}", 1},
+		{"other credentials", func(i int) string { return "Bearer " + strconv.Itoa(i) }, "{}", 3},
+		{"body too long", func(int) string { return "Bearer a" }, "0123456789", 3},
+	} {
+		var calls atomic.Int32
+		entered, release := make(chan struct{}, 1), make(chan struct{})
+		c := Decorate(gate("shared", &calls, entered, release), CoalesceByBody(8))
+		concurrently(t, c, 3, func(i int) *http.Request {
+			r := newRequest(t, http.MethodPost, "http://example.com/", unseekable(tc.body))
+			r.Header.Set("Authorization", tc.auth(i))
+			return r
+		}, entered, release)
+		if calls.Load() != tc.calls {
+			t.Errorf("%s: made %d upstream calls, want %d", tc.name, calls.Load(), tc.calls)
+		}
+	}
+}
+
+func TestCoalesceByBodyKeepsBody(t *testing.T) {
+	echo := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return newResponse(r, http.StatusOK, string(body)), nil
+	})
+	c := Decorate(echo, CoalesceByBody(4))
+	for _, tc := range []struct {
+		body io.Reader
+		want string
+	}{
+		{strings.NewReader("longer, rewindable"), "longer, rewindable"},
+		{unseekable("abc"), "abc"},
+		{unseekable("longer than four"), "longer than four"},
+	} {
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodPost, "http://example.com/", tc.body))); got != tc.want {
+			t.Errorf("upstream got body %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	key := func(r *http.Request) string { return r.URL.Query().Get("user") }
+	c := Decorate(next, Debounce(key, time.Second), WithClock(clock))
+	send := func(url string) error {
+		res, err := c.Do(newRequest(t, http.MethodPost, url, nil))
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	if err := send("http://example.com/?user=a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := send("http://example.com/?user=a"); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("repeated request: Do() error = %v, want %v", err, ErrDuplicate)
+	}
+	for _, url := range []string{"http://example.com/?user=b", "http://example.com/", "http://example.com/"} {
+		if err := send(url); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+	}
+	clock.Advance(time.Second)
+	if err := send("http://example.com/?user=a"); err != nil {
+		t.Fatalf("after the window: %v", err)
+	}
+	if got := calls.Load(); got != 5 {
+		t.Fatalf("Client got %d requests, want 5", got)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CompressRequest returns a Decorator that gzips every request body and sets
+// the Content-Encoding header accordingly. Bodies that already have a
+// Content-Encoding are sent as they are.
+func CompressRequest() Decorator {
+	return CompressRequestIfLarger(0)
+}
+
+// CompressRequestIfLarger returns a Decorator like CompressRequest that only
+// gzips bodies larger than minBytes, for which compressing is worth the CPU.
+// Smaller bodies are sent as they are, without a Content-Encoding. Larger ones
+// are gzipped as they are sent, after reading no more than minBytes+1 bytes
+// ahead.
+func CompressRequestIfLarger(minBytes int) Decorator {
+	if minBytes < 0 {
+		minBytes = 0
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body == nil || r.Body == http.NoBody || r.Header.Get("Content-Encoding") != "" {
+				return c.Do(r)
+			}
+			if r.ContentLength > 0 && r.ContentLength <= int64(minBytes) {
+				return c.Do(r)
+			}
+			_, whole, err := peekBody(r, int64(minBytes))
+			if err != nil {
+				return nil, err
+			}
+			if whole {
+				return c.Do(r)
+			}
+			gzipBody(r)
+			return c.Do(r)
+		})
+	}
+}
+
+// gzipBody replaces the body of r with one that reads it gzipped, and sets
+// the Content-Encoding, GetBody and ContentLength accordingly.
+func gzipBody(r *http.Request) {
+	r.Header.Set("Content-Encoding", "gzip")
+	r.ContentLength = -1
+	r.Body = gzipReader(r.Body)
+	if getBody := r.GetBody; getBody != nil {
+		r.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return gzipReader(body), nil
+		}
+	}
+}
+
+// gzippedBody is a body gzipped through a pipe as it is read, which closes
+// the body it reads once read in full or closed.
+type gzippedBody struct {
+	pipedBody
+	closeBody func()
+}
+
+// gzipReader returns a gzippedBody reading body.
+func gzipReader(body io.ReadCloser) io.ReadCloser {
+	var once sync.Once
+	closeBody := func() { once.Do(func() { body.Close() }) }
+	return &gzippedBody{pipedBody: pipedBody{write: func(w io.Writer) error {
+		defer closeBody()
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, body); err != nil {
+			return err
+		}
+		return zw.Close()
+	}}, closeBody: closeBody}
+}
+
+func (b *gzippedBody) Close() error {
+	err := b.pipedBody.Close()
+	b.closeBody()
+	return err
+}
+
+// DecodeFallbackHeader is the response header in which an AcceptGzip
+// Decorator flags, with "gzip", responses whose body failed to decompress and
+// is returned as received instead.
+const DecodeFallbackHeader = "X-Decode-Fallback"
+
+// ErrResponseTooLarge is returned by an AcceptGzip Decorator for response
+// bodies larger than allowed, compressed or not.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// AcceptGzip returns a Decorator that asks for gzipped responses, unless the
+// request sets its own Accept-Encoding, and decompresses the gzipped
+// responses it gets. A body that fails to decompress, e.g. a corrupt stream,
+// is returned as received, with the DecodeFallbackHeader set, rather than
+// failing the request. Bodies are read in full to allow for that fallback, up
+// to maxBody bytes both before and after decompressing; larger ones fail
+// with ErrResponseTooLarge.
+func AcceptGzip(maxBody int64) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Header.Get("Accept-Encoding") == "" {
+				r.Header.Set("Accept-Encoding", "gzip")
+			}
+			res, err := c.Do(r)
+			if err != nil || !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+				return res, err
+			}
+			raw, err := io.ReadAll(io.LimitReader(res.Body, maxBody+1))
+			res.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(raw)) > maxBody {
+				return nil, fmt.Errorf("%w: over %d bytes compressed", ErrResponseTooLarge, maxBody)
+			}
+			body, err := gunzip(raw, maxBody)
+			switch {
+			case errors.Is(err, ErrResponseTooLarge):
+				return nil, err
+			case err != nil:
+				res.Header.Set(DecodeFallbackHeader, "gzip")
+				body = raw
+			default:
+				res.Header.Del("Content-Encoding")
+				res.Uncompressed = true
+			}
+			res.Header.Del("Content-Length")
+			res.ContentLength = int64(len(body))
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			return res, nil
+		})
+	}
+}
+
+// gunzip decompresses data, failing with ErrResponseTooLarge if it holds
+// more than max bytes.
+func gunzip(data []byte, max int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(io.LimitReader(zr, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, fmt.Errorf("%w: over %d bytes decompressed", ErrResponseTooLarge, max)
+	}
+	return body, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// closeTracker is a request body that records whether it was closed.
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTracker) Close() error {
+	b.closed = true
+	return nil
+}
+
+// gunzipped returns a Client that answers with the gunzipped body and the
+// Content-Encoding of every request it gets.
+func gunzipped() Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(strings.NewReader(string(body)))
+			if err != nil {
+				return nil, err
+			}
+			if body, err = io.ReadAll(zr); err != nil {
+				return nil, err
+			}
+		}
+		return newResponse(r, http.StatusOK, r.Header.Get("Content-Encoding")+" "+string(body)), nil
+	})
+}
+
+func TestCompressRequestIfLarger(t *testing.T) {
+	c := Decorate(gunzipped(), CompressRequestIfLarger(8))
+	for _, tc := range []struct{ body, want string }{
+		{"small", " small"},
+		{"larger body", "gzip larger body"},
+	} {
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable(tc.body)))); got != tc.want {
+			t.Errorf("sent %q, server saw %q, want %q", tc.body, got, tc.want)
+		}
+	}
+
+	r := newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("larger body"))
+	body := &closeTracker{Reader: r.Body}
+	r.Body = body
+	if got := bodyString(t, do(t, c, r)); got != "gzip larger body" {
+		t.Fatalf("server saw %q, want the gzipped body", got)
+	}
+	if !body.closed {
+		t.Fatal("the replaced request body was not closed")
+	}
+	if r.ContentLength == int64(len("larger body")) {
+		t.Fatal("ContentLength was not updated")
+	}
+}
+
+// countingReader is a Reader that counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (rd *countingReader) Read(p []byte) (int, error) {
+	n, err := rd.Reader.Read(p)
+	rd.n += n
+	return n, err
+}
+
+func TestCompressRequestIfLargerStreams(t *testing.T) {
+	large := strings.Repeat("payload ", 1<<16)
+	src := &countingReader{Reader: strings.NewReader(large)}
+	var ahead int
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		ahead = src.n
+		return gunzipped().Do(r)
+	})
+	c := Decorate(next, CompressRequestIfLarger(8))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodPost, "http://example.com/", io.NopCloser(src)))); got != "gzip "+large {
+		t.Fatalf("server saw %d bytes, want the gzipped body", len(got))
+	}
+	if ahead > 9 {
+		t.Fatalf("read %d bytes ahead, want at most 9", ahead)
+	}
+
+	var resent string
+	next = ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		res, err := gunzipped().Do(&http.Request{Header: r.Header, Body: body})
+		if err != nil {
+			return nil, err
+		}
+		resent = bodyString(t, res)
+		return gunzipped().Do(r)
+	})
+	c = Decorate(next, CompressRequestIfLarger(8))
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("larger body"))).Body.Close()
+	if resent != "gzip larger body" {
+		t.Fatalf("GetBody sent %q, want the gzipped body", resent)
+	}
+}
+
+// gzipped returns s gzipped.
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf strings.Builder
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestAcceptGzip(t *testing.T) {
+	var accepted string
+	serve := func(body string) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			accepted = r.Header.Get("Accept-Encoding")
+			res := newResponse(r, http.StatusOK, body)
+			res.Header.Set("Content-Encoding", "gzip")
+			return res, nil
+		})
+	}
+
+	res := do(t, Decorate(serve(gzipped(t, "hello")), AcceptGzip(64)), newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if got := bodyString(t, res); got != "hello" || accepted != "gzip" || res.Header.Get("Content-Encoding") != "" || !res.Uncompressed {
+		t.Fatalf("got %q with Content-Encoding %q after asking for %q, want it decompressed", got, res.Header.Get("Content-Encoding"), accepted)
+	}
+
+	res = do(t, Decorate(serve("not gzip"), AcceptGzip(64)), newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if got := bodyString(t, res); got != "not gzip" || res.Header.Get(DecodeFallbackHeader) != "gzip" {
+		t.Fatalf("corrupt stream: got %q with %s %q, want it as received and flagged", got, DecodeFallbackHeader, res.Header.Get(DecodeFallbackHeader))
+	}
+
+	for _, body := range []string{gzipped(t, strings.Repeat("x", 1000)), strings.Repeat("x", 100)} {
+		if _, err := Decorate(serve(body), AcceptGzip(64)).Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("%d bytes: Do() error = %v, want %v", len(body), err, ErrResponseTooLarge)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BulkheadPerHost returns a Decorator that allows at most max requests in
+// flight to each host at once. Requests over the limit wait for a slot to
+// free up, or until their context is done. A non-positive max disables the
+// limit. Hosts without requests in flight are forgotten.
+func BulkheadPerHost(max int) Decorator {
+	type bulkhead struct {
+		sem   semaphore
+		users int
+	}
+	return func(c Client) Client {
+		if max <= 0 {
+			return c
+		}
+		var (
+			mu        sync.Mutex
+			bulkheads = map[string]*bulkhead{}
+		)
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			host := r.URL.Host
+			mu.Lock()
+			b, ok := bulkheads[host]
+			if !ok {
+				b = &bulkhead{sem: make(semaphore, max)}
+				bulkheads[host] = b
+			}
+			b.users++
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				if b.users--; b.users == 0 {
+					delete(bulkheads, host)
+				}
+				mu.Unlock()
+			}()
+
+			if err := b.sem.acquire(r); err != nil {
+				return nil, err
+			}
+			defer b.sem.release()
+			return c.Do(r)
+		})
+	}
+}
+
+// LockPerKey returns a Decorator that never lets two requests with the same
+// key be in flight at once: a request waits for the one holding its key to
+// complete, or until its context is done. Requests with different keys, or an
+// empty key, proceed in parallel.
+func LockPerKey(key func(*http.Request) string) Decorator {
+	type lock struct {
+		sem   semaphore
+		users int
+	}
+	return func(c Client) Client {
+		var (
+			mu    sync.Mutex
+			locks = map[string]*lock{}
+		)
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			k := key(r)
+			if k == "" {
+				return c.Do(r)
+			}
+			mu.Lock()
+			l, ok := locks[k]
+			if !ok {
+				l = &lock{sem: make(semaphore, 1)}
+				locks[k] = l
+			}
+			l.users++
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				if l.users--; l.users == 0 {
+					delete(locks, k)
+				}
+				mu.Unlock()
+			}()
+
+			if err := l.sem.acquire(r); err != nil {
+				return nil, err
+			}
+			defer l.sem.release()
+			return c.Do(r)
+		})
+	}
+}
+
+// ErrPoolExhausted is returned by a PoolExhaustion Decorator for requests
+// that found no connection available in time.
+var ErrPoolExhausted = errors.New("connection pool exhausted")
+
+// PoolExhaustion returns a Decorator that handles the errors that
+// isExhausted reports as caused by an exhausted connection pool, e.g. those
+// of a pooling Transport that fails instead of queuing. With a zero wait,
+// such requests fail fast with ErrPoolExhausted; otherwise they are retried
+// with a growing delay, applying backpressure, until they find a connection
+// or wait has passed. A nil isExhausted matches errors wrapping
+// ErrPoolExhausted.
+func PoolExhaustion(wait time.Duration, isExhausted func(error) bool) Decorator {
+	if isExhausted == nil {
+		isExhausted = func(err error) bool { return errors.Is(err, ErrPoolExhausted) }
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			clock := clockFrom(r.Context())
+			deadline := clock.Now().Add(wait)
+			delay := 10 * time.Millisecond
+			for {
+				res, err := c.Do(r)
+				if err == nil || !isExhausted(err) {
+					return res, err
+				}
+				remaining := deadline.Sub(clock.Now())
+				if remaining <= 0 {
+					return nil, fmt.Errorf("%w: %w", ErrPoolExhausted, err)
+				}
+				if delay > remaining {
+					delay = remaining
+				}
+				if err := sleep(r.Context(), delay); err != nil {
+					return nil, err
+				}
+				if err := rewind(r); err != nil {
+					return nil, err
+				}
+				delay *= 2
+			}
+		})
+	}
+}
+
+// semaphore limits concurrency to its capacity.
+type semaphore chan struct{}
+
+// acquire takes a slot for r, waiting for one to free up unless r's context
+// is done first.
+func (s semaphore) acquire(r *http.Request) error {
+	defer observeWait(r, time.Now())
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+// release gives back a slot taken by acquire.
+func (s semaphore) release() {
+	<-s
+}
+
+// Priority returns a Decorator that gives every request the given priority
+// level, which a PriorityLimiter it wraps serves higher levels first by.
+// Requests without a level have level 0.
+func Priority(level int) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return c.Do(r.WithContext(context.WithValue(r.Context(), priorityKey, level)))
+		})
+	}
+}
+
+// PriorityFromContext returns the priority level of the request with the
+// given context.
+func PriorityFromContext(ctx context.Context) int {
+	level, _ := ctx.Value(priorityKey).(int)
+	return level
+}
+
+// A PriorityLimiter limits how many requests can be in flight at once through
+// its Decorator. Requests over the limit are queued, and as slots free up
+// they go to the queued request with the highest Priority level, the
+// earliest queued first among equals.
+type PriorityLimiter struct {
+	max int
+
+	mu       sync.Mutex
+	inflight int
+	queue    priorityQueue
+	queued   uint64
+}
+
+// NewPriorityLimiter returns a PriorityLimiter that allows up to max requests
+// in flight at once. A non-positive max disables the limit.
+func NewPriorityLimiter(max int) *PriorityLimiter {
+	return &PriorityLimiter{max: max}
+}
+
+// Decorator returns a Decorator that subjects a Client's requests to l. A
+// queued request gives up waiting once its context is done.
+func (l *PriorityLimiter) Decorator() Decorator {
+	return func(c Client) Client {
+		if l.max <= 0 {
+			return c
+		}
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if err := l.acquire(r); err != nil {
+				return nil, err
+			}
+			defer l.release()
+			return c.Do(r)
+		})
+	}
+}
+
+// acquire takes a slot for r, queuing for one unless r's context is done
+// first.
+func (l *PriorityLimiter) acquire(r *http.Request) error {
+	defer observeWait(r, time.Now())
+	l.mu.Lock()
+	if l.inflight < l.max && l.queue.Len() == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return nil
+	}
+	l.queued++
+	w := &waiter{level: PriorityFromContext(r.Context()), order: l.queued, ready: make(chan struct{})}
+	heap.Push(&l.queue, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-r.Context().Done():
+		l.mu.Lock()
+		queued := w.index >= 0
+		if queued {
+			heap.Remove(&l.queue, w.index)
+		}
+		l.mu.Unlock()
+		if !queued {
+			// The slot was handed over just as the context was done.
+			l.release()
+		}
+		return r.Context().Err()
+	}
+}
+
+// release gives a slot taken by acquire to the next queued request, if any.
+func (l *PriorityLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.queue.Len() == 0 {
+		l.inflight--
+		return
+	}
+	close(heap.Pop(&l.queue).(*waiter).ready)
+}
+
+// waiter is a request queued in a PriorityLimiter.
+type waiter struct {
+	level int
+	order uint64
+	ready chan struct{}
+	index int
+}
+
+// priorityQueue is a heap of waiters, highest level and earliest first.
+type priorityQueue []*waiter
+
+func (q priorityQueue) Len() int { return len(q) }
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].level != q[j].level {
+		return q[i].level > q[j].level
+	}
+	return q[i].order < q[j].order
+}
+
+func (q priorityQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *priorityQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// WaitMetrics returns a Decorator that observes in wait, for the limiting
+// Decorators it wraps, how long in nanoseconds each request waited for its
+// turn, apart from its latency. These are BulkheadPerHost, LockPerKey,
+// PriorityLimiter and RateLimitByKey.
+func WaitMetrics(wait Histogram) Decorator {
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			return c.Do(r.WithContext(context.WithValue(r.Context(), waitObserverKey, wait)))
+		}, wait)
+	}
+}
+
+// observeWait observes the time since start in the Histogram of the
+// WaitMetrics Decorator wrapping r, if any.
+func observeWait(r *http.Request, start time.Time) {
+	if wait, ok := r.Context().Value(waitObserverKey).(Histogram); ok {
+		wait.Observe(time.Since(start).Nanoseconds())
+	}
+}
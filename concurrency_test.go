@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holding returns a Client that holds every request to the host "slow" until
+// release is closed, signalling on entered when it gets one, and answers the
+// others right away.
+func holding(entered chan<- struct{}, release <-chan struct{}) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+}
+
+// timeout returns r with a context that times out after d.
+func timeout(t *testing.T, r *http.Request, d time.Duration) *http.Request {
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	t.Cleanup(cancel)
+	return r.WithContext(ctx)
+}
+
+func TestBulkheadPerHost(t *testing.T) {
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	c := Decorate(holding(entered, release), BulkheadPerHost(1))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		do(t, c, newRequest(t, http.MethodGet, "http://slow/", nil)).Body.Close()
+	}()
+	<-entered
+
+	r := timeout(t, newRequest(t, http.MethodGet, "http://slow/", nil), 10*time.Millisecond)
+	if _, err := c.Do(r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("same host: Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://fast/", nil)).Body.Close()
+
+	// The slot is free again once the first request completes.
+	close(release)
+	<-done
+	do(t, c, timeout(t, newRequest(t, http.MethodGet, "http://slow/", nil), time.Second)).Body.Close()
+}
+
+func TestBulkheadPerHostUnlimited(t *testing.T) {
+	entered, release := make(chan struct{}, 2), make(chan struct{})
+	c := Decorate(holding(entered, release), BulkheadPerHost(0))
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(t, c, newRequest(t, http.MethodGet, "http://slow/", nil)).Body.Close()
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered:
+		case <-time.After(time.Second):
+			t.Fatal("a request to the same host waited, want no limit")
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestLockPerKey(t *testing.T) {
+	entered, release := make(chan struct{}, 2), make(chan struct{})
+	c := Decorate(holding(entered, release), LockPerKey(func(r *http.Request) string { return r.URL.Query().Get("k") }))
+	var wg sync.WaitGroup
+	for _, url := range []string{"http://slow/?k=a", "http://slow/?k="} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(t, c, newRequest(t, http.MethodGet, url, nil)).Body.Close()
+		}()
+	}
+	// Both are held at once: the empty key doesn't lock.
+	<-entered
+	<-entered
+
+	r := timeout(t, newRequest(t, http.MethodGet, "http://slow/?k=a", nil), 10*time.Millisecond)
+	if _, err := c.Do(r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("same key: Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	do(t, c, timeout(t, newRequest(t, http.MethodGet, "http://fast/?k=b", nil), time.Second)).Body.Close()
+
+	close(release)
+	wg.Wait()
+	do(t, c, timeout(t, newRequest(t, http.MethodGet, "http://fast/?k=a", nil), time.Second)).Body.Close()
+}
+
+func TestPriorityLimiter(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		mu     sync.Mutex
+		served []string
+	)
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		served = append(served, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/first" {
+			<-release
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	limiter := NewPriorityLimiter(1)
+	queued := func() int {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return limiter.queue.Len()
+	}
+	var wg sync.WaitGroup
+	send := func(path string, level int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := Decorate(next, limiter.Decorator(), Priority(level))
+			do(t, c, newRequest(t, http.MethodGet, "http://example.com"+path, nil)).Body.Close()
+		}()
+	}
+	send("/first", 0)
+	for {
+		mu.Lock()
+		started := len(served) > 0
+		mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	send("/low", 0)
+	for queued() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	send("/high", 1)
+	for queued() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	r := timeout(t, newRequest(t, http.MethodGet, "http://example.com/late", nil), 10*time.Millisecond)
+	if _, err := Decorate(next, limiter.Decorator()).Do(r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("queued request: Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	wg.Wait()
+	if want := []string{"/first", "/high", "/low"}; !reflect.DeepEqual(served, want) {
+		t.Fatalf("served %v, want %v", served, want)
+	}
+}
+
+func TestPriorityLimiterUnlimited(t *testing.T) {
+	entered, release := make(chan struct{}, 2), make(chan struct{})
+	c := Decorate(holding(entered, release), NewPriorityLimiter(0).Decorator())
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(t, c, newRequest(t, http.MethodGet, "http://slow/", nil)).Body.Close()
+		}()
+	}
+	<-entered
+	<-entered
+	close(release)
+	wg.Wait()
+}
+
+func TestPoolExhaustion(t *testing.T) {
+	exhausted := func(n int, calls *int) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			*calls++
+			if *calls <= n {
+				return nil, fmt.Errorf("dialing: %w", ErrPoolExhausted)
+			}
+			return newResponse(r, http.StatusOK, ""), nil
+		})
+	}
+
+	clock := newFakeClock()
+	var calls int
+	c := Decorate(exhausted(2, &calls), PoolExhaustion(time.Second, nil), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}; calls != 3 || !reflect.DeepEqual(clock.Waits(), want) {
+		t.Fatalf("%d calls after waiting %v, want 3 after %v", calls, clock.Waits(), want)
+	}
+
+	clock, calls = newFakeClock(), 0
+	c = Decorate(exhausted(100, &calls), PoolExhaustion(100*time.Millisecond, nil), WithClock(clock))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("Do() error = %v, want %v", err, ErrPoolExhausted)
+	}
+	if want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 30 * time.Millisecond}; !reflect.DeepEqual(clock.Waits(), want) {
+		t.Fatalf("waited %v, want %v", clock.Waits(), want)
+	}
+
+	calls = 0
+	c = Decorate(exhausted(100, &calls), PoolExhaustion(0, func(err error) bool { return errors.Is(err, ErrPoolExhausted) }))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); !errors.Is(err, ErrPoolExhausted) || calls != 1 {
+		t.Fatalf("with no wait, Do() error = %v after %d calls, want %v after 1", err, calls, ErrPoolExhausted)
+	}
+}
+
+// observations is a Histogram that records every value observed.
+type observations struct {
+	mu     sync.Mutex
+	values []int64
+}
+
+func (o *observations) Observe(v int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.values = append(o.values, v)
+}
+
+func TestWaitMetrics(t *testing.T) {
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	var wait observations
+	c := Decorate(holding(entered, release), BulkheadPerHost(1), WaitMetrics(&wait))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		do(t, c, newRequest(t, http.MethodGet, "http://slow/", nil)).Body.Close()
+	}()
+	<-entered
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	do(t, c, newRequest(t, http.MethodGet, "http://slow/", nil)).Body.Close()
+	<-done
+
+	wait.mu.Lock()
+	defer wait.mu.Unlock()
+	if len(wait.values) != 2 || wait.values[1] < int64(20*time.Millisecond) {
+		t.Fatalf("observed waits %v, want a short one and one of at least 20ms", wait.values)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrNotJSON is returned by a RequireJSON Decorator for responses whose
+// Content-Type isn't JSON.
+var ErrNotJSON = errors.New("response is not JSON")
+
+// RequireJSON returns a Decorator that sets the Content-Type of every request
+// with a body to application/json and rejects responses with content whose
+// Content-Type isn't JSON, closing their body and returning ErrNotJSON.
+func RequireJSON() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			res, err := c.Do(r)
+			if err != nil || !hasContent(res) {
+				return res, err
+			}
+			if ct := res.Header.Get("Content-Type"); !isJSON(ct) {
+				res.Body.Close()
+				return nil, fmt.Errorf("%w: Content-Type %q", ErrNotJSON, ct)
+			}
+			return res, nil
+		})
+	}
+}
+
+// hasContent reports whether res can carry a body.
+func hasContent(res *http.Response) bool {
+	return res.StatusCode != http.StatusNoContent &&
+		res.StatusCode != http.StatusNotModified &&
+		res.ContentLength != 0 &&
+		(res.Request == nil || res.Request.Method != http.MethodHead)
+}
+
+// isJSON reports whether the media type ct is application/json or a
+// +json structured syntax suffix type.
+func isJSON(ct string) bool {
+	mediatype, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mediatype == "application/json" || strings.HasSuffix(mediatype, "+json")
+}
+
+// NormalizeJSON returns a Decorator that rewrites the body of every JSON
+// response in a canonical form, with object keys sorted and no insignificant
+// whitespace. Numbers are kept exactly as sent. Bodies that aren't valid
+// JSON, or are longer than 10 MiB, are left as they are.
+func NormalizeJSON() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil || !hasContent(res) || !isJSON(res.Header.Get("Content-Type")) {
+				return res, err
+			}
+			body, rest, err := readLimited(res.Body, maxBufferedBody)
+			if err != nil {
+				return nil, err
+			}
+			if rest != nil {
+				res.Body = rest
+				return res, nil
+			}
+			if normalized, ok := normalizeJSON(body); ok {
+				body = normalized
+				res.ContentLength = int64(len(body))
+				res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			return res, nil
+		})
+	}
+}
+
+// normalizeJSON returns the canonical form of the JSON document data, and
+// whether data was valid JSON.
+func normalizeJSON(data []byte) ([]byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	var normalized bytes.Buffer
+	enc := json.NewEncoder(&normalized)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, false
+	}
+	return bytes.TrimSuffix(normalized.Bytes(), []byte("\n")), true
+}
+
+// FormToJSON returns a Decorator that re-encodes every URL-encoded form
+// request body as a JSON object, with fields holding a single value as
+// strings and those holding several as arrays of strings, and sets the
+// Content-Type and GetBody of the request accordingly. Form bodies longer than
+// 10 MiB fail with ErrRequestTooLarge.
+func FormToJSON() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if r.Body == nil || r.Body == http.NoBody || mt != "application/x-www-form-urlencoded" {
+				return c.Do(r)
+			}
+			body, rest, err := readLimited(r.Body, maxBufferedBody)
+			if err != nil {
+				return nil, err
+			}
+			if rest != nil {
+				rest.Close()
+				return nil, fmt.Errorf("%w: form body of more than %d bytes", ErrRequestTooLarge, maxBufferedBody)
+			}
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return nil, fmt.Errorf("parsing form body: %w", err)
+			}
+			fields := make(map[string]interface{}, len(form))
+			for name, values := range form {
+				if len(values) == 1 {
+					fields[name] = values[0]
+				} else {
+					fields[name] = values
+				}
+			}
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return nil, err
+			}
+			r.Header.Set("Content-Type", "application/json")
+			r.ContentLength = int64(len(data))
+			r.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			}
+			r.Body, _ = r.GetBody()
+			return c.Do(r)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRequireJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{"json", http.MethodGet, "application/json; charset=utf-8", "{}", false},
+		{"json suffix", http.MethodGet, "application/problem+json", "{}", false},
+		{"html", http.MethodGet, "text/html", "<html>", true},
+		{"no content", http.MethodGet, "text/html", "", false},
+		{"head", http.MethodHead, "text/html", "<html>", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent string
+			c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+				sent = r.Header.Get("Content-Type")
+				res := newResponse(r, http.StatusOK, tc.body)
+				res.Header.Set("Content-Type", tc.contentType)
+				return res, nil
+			}), RequireJSON())
+
+			_, err := c.Do(newRequest(t, tc.method, "http://example.com/", strings.NewReader("{}")))
+			if got := errors.Is(err, ErrNotJSON); got != tc.wantErr {
+				t.Fatalf("Do() error = %v, want ErrNotJSON: %v", err, tc.wantErr)
+			}
+			if sent != "application/json" {
+				t.Fatalf("request Content-Type = %q, want application/json", sent)
+			}
+		})
+	}
+}
+
+func TestNormalizeJSON(t *testing.T) {
+	for body, want := range map[string]string{
+		"{ \"b\": [1, 2.50],\n  \"a\": {\"y\": \"<\", \"x\": null} }": `{"a":{"x":null,"y":"<"},"b":[1,2.50]}`,
+		`12345678901234567890`: `12345678901234567890`,
+		`{"a": 1} trailing`:    `{"a": 1} trailing`,
+		`not json`:             `not json`,
+	} {
+		c := Decorate(jsonResponse(body), NormalizeJSON())
+		res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+		if got := bodyString(t, res); got != want {
+			t.Errorf("%s: body = %s, want %s", body, got, want)
+		}
+		if res.ContentLength != int64(len(want)) {
+			t.Errorf("%s: ContentLength = %d, want %d", body, res.ContentLength, len(want))
+		}
+	}
+}
+
+func TestNormalizeJSONLimits(t *testing.T) {
+	long := "{" + strings.Repeat(" ", maxBufferedBody) + "}"
+	if got := bodyString(t, do(t, Decorate(jsonResponse(long), NormalizeJSON()), newRequest(t, http.MethodGet, "http://example.com/", nil))); got != long {
+		t.Fatalf("body of %d bytes changed to %d bytes, want it left as it is", len(long), len(got))
+	}
+
+	failing := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, "")
+		res.ContentLength = -1
+		res.Header.Set("Content-Type", "application/json")
+		res.Body = io.NopCloser(iotest.ErrReader(errFlaky))
+		return res, nil
+	})
+	if res, err := Decorate(failing, NormalizeJSON()).Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); res != nil || err != errFlaky {
+		t.Fatalf("Do() = %v, %v, want nil, %v", res, err, errFlaky)
+	}
+}
+
+func TestFormToJSON(t *testing.T) {
+	var sent []string
+	var contentTypes []string
+	next := bodies(0, &sent)
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		return next.Do(r)
+	}), FormToJSON())
+	post := func(contentType, body string) {
+		r := newRequest(t, http.MethodPost, "http://example.com/", unseekable(body))
+		r.Header.Set("Content-Type", contentType)
+		do(t, c, r).Body.Close()
+	}
+
+	post("application/x-www-form-urlencoded; charset=utf-8", "name=ana&tag=a&tag=b&q=x%26y")
+	post("text/plain", "name=ana")
+	if want := []string{`{"name":"ana","q":"x\u0026y","tag":["a","b"]}`, "name=ana"}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent bodies %q, want %q", sent, want)
+	}
+	if want := []string{"application/json", "text/plain"}; !reflect.DeepEqual(contentTypes, want) {
+		t.Fatalf("sent Content-Types %q, want %q", contentTypes, want)
+	}
+
+	r := newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("a=%zz"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := c.Do(r); err == nil {
+		t.Fatal("malformed form: got no error")
+	}
+	r = newRequest(t, http.MethodPost, "http://example.com/", unseekable("a="+strings.Repeat("x", maxBufferedBody)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := c.Do(r); !errors.Is(err, ErrRequestTooLarge) {
+		t.Fatalf("large form: Do() error = %v, want %v", err, ErrRequestTooLarge)
+	}
+}
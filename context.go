@@ -0,0 +1,28 @@
+package main
+
+// contextKey is the type of the keys under which Decorators store values in
+// a request's context.
+type contextKey int
+
+const (
+	layersKey contextKey = iota
+	retryObserverKey
+	stopwatchKey
+	clockKey
+	baggageKey
+	streamingKey
+	correlationIDKey
+	layerTraceKey
+	layerFrameKey
+	retryLimiterKey
+	backendKey
+	sequenceKey
+	priorityKey
+	ifMatchKey
+	waitObserverKey
+	overridesKey
+	breakerKey
+	memoryBudgetKey
+	attemptBudgetKey
+	spanKey
+)
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"hash/fnv"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -30,7 +37,7 @@ type Decorator func(Client) Client
 func Logging(l *log.Logger) Decorator {
 	return func(c Client) Client {
 		return ClientFunc(func(r *http.Request) (*http.Response, error) {
-			l.Printf("%s: %s %s", r.UserAgent, r.Method, r.URL)
+			l.Printf("%s: %s %s", r.UserAgent(), r.Method, r.URL)
 			return c.Do(r)
 		})
 	}
@@ -40,38 +47,61 @@ func Logging(l *log.Logger) Decorator {
 // Orthogonal concern 2: instrumentation
 func Instrumentation(requests Counter, latency Histogram) Decorator {
 	return func(c Client) Client {
-		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
 			defer func(start time.Time) {
 				latency.Observe(time.Since(start).Nanoseconds())
 				requests.Add(1)
 			}(time.Now())
 			return c.Do(r)
-		})
+		}, requests, latency)
 	}
 }
 
 // FaultTolerance returns a Decorator that extends a Client with fault tolerance
-// configured with the given attempts and backoff duration.
+// configured with the given attempts and backoff duration. The n-th retry
+// waits n times backoff.
 // Orthogonal concern 3: fault tolerance
 func FaultTolerance(attempts int, backoff time.Duration) Decorator {
 	return func(c Client) Client {
-		return ClientFunc(func(r *http.Request) (res *http.Response, err error) {
-			for i := 0; i <= attempts; i++ {
-				if res, err = c.Do(r); err == nil {
-					break
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(c, r, attempts, linear(backoff), failed)
+		})
+	}
+}
+
+// FaultToleranceJittered returns a Decorator like FaultTolerance that
+// randomizes each backoff by up to the given fraction of it in either
+// direction, e.g. 0.1 for ±10%, so that clients failing together don't
+// retry together.
+func FaultToleranceJittered(attempts int, backoff time.Duration, jitter float64) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(c, r, attempts, jittered(linear(backoff), jitter), failed)
+		})
+	}
+}
+
+// FaultToleranceCapped returns a Decorator like FaultTolerance whose backoff
+// between attempts never exceeds maxBackoff.
+func FaultToleranceCapped(attempts int, backoff, maxBackoff time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(c, r, attempts, func(n int) time.Duration {
+				if d := linear(backoff)(n); d < maxBackoff {
+					return d
 				}
-				time.Sleep(backoff * time.Duration(i))
-			}
-			return res, err
+				return maxBackoff
+			}, failed)
 		})
 	}
 }
 
 // Authorization returns a Decorator that authorizes every Client request
-// with the given token.
+// with the given token, replacing any Authorization already set so that a
+// request never carries more than one.
 // Orthogonal concern 4: authorization
 func Authorization(token string) Decorator {
-	return Header("Authorization", token)
+	return SetHeader("Authorization", token)
 }
 
 // Header returns a Decorator that adds the given HTTP header to every request
@@ -85,14 +115,26 @@ func Header(name, value string) Decorator {
 	}
 }
 
+// SetHeader returns a Decorator that sets the given HTTP header on every
+// request done by a Client, replacing any values it already has.
+func SetHeader(name, value string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set(name, value)
+			return c.Do(r)
+		})
+	}
+}
+
 // LoadBalancing returns a Decorator that load balances a Client's requests across
-// multiple backends using the given Director.
+// multiple backends using the given Director. The chosen backend is recorded
+// in the request context, see BackendFromContext.
 // Orthogonal concern 5: load balancing
 func LoadBalancing(dir Director) Decorator {
 	return func(c Client) Client {
 		return ClientFunc(func(r *http.Request) (*http.Response, error) {
 			dir(r)
-			return c.Do(r)
+			return c.Do(r.WithContext(context.WithValue(r.Context(), backendKey, r.URL.Host)))
 		})
 	}
 }
@@ -119,16 +161,173 @@ func Random(seed int64, backends ...string) Director {
 	}
 }
 
+// ShuffleBackends returns a Director which shuffles the given backends for
+// every request and picks the first one, spreading requests evenly at random.
+// Unlike Random, it is safe for concurrent use.
+func ShuffleBackends(seed int64, backends ...string) Director {
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(seed))
+	return func(r *http.Request) {
+		if len(backends) == 0 {
+			return
+		}
+		shuffled := append([]string(nil), backends...)
+		mu.Lock()
+		rnd.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		mu.Unlock()
+		r.URL.Host = shuffled[0]
+	}
+}
+
+// WeightedRandom returns a Director which randomly picks one of the given
+// backends with a probability proportional to its weight. Backends with a
+// weight of zero or less are never picked.
+func WeightedRandom(seed int64, weights map[string]int) Director {
+	var (
+		backends []string
+		total    int
+	)
+	for backend, weight := range weights {
+		if weight > 0 {
+			backends = append(backends, backend)
+			total += weight
+		}
+	}
+	// Sorted so that a given seed always yields the same picks.
+	sort.Strings(backends)
+
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(seed))
+	return func(r *http.Request) {
+		if total == 0 {
+			return
+		}
+		mu.Lock()
+		n := rnd.Intn(total)
+		mu.Unlock()
+		for _, backend := range backends {
+			if n -= weights[backend]; n < 0 {
+				r.URL.Host = backend
+				return
+			}
+		}
+	}
+}
+
+// ByContentType returns a Director which sends every request to the backend
+// mapped to the media type of its Content-Type, e.g. "multipart/form-data",
+// or else to the one mapped to its type range, e.g. "multipart/*", or else to
+// the fallback backend.
+func ByContentType(backends map[string]string, fallback string) Director {
+	return func(r *http.Request) {
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if backend, ok := backends[mt]; ok {
+			r.URL.Host = backend
+		} else if backend, ok := backends[strings.SplitN(mt, "/", 2)[0]+"/*"]; ok && mt != "" {
+			r.URL.Host = backend
+		} else {
+			r.URL.Host = fallback
+		}
+	}
+}
+
+// hashReplicas is the number of points a node of weight one gets on the ring
+// of a ConsistentHash Director.
+const hashReplicas = 100
+
+// ConsistentHash returns a Director which sends every request to the node
+// that owns the hash of its key on a consistent hash ring, so that requests
+// with the same key keep going to the same node, e.g. the cache holding it.
+// A node owns a share of the ring proportional to its weight, and adding or
+// removing a node only moves the keys of its share. Nodes with a weight of
+// zero or less get no share.
+func ConsistentHash(key func(*http.Request) string, weights map[string]int) Director {
+	type point struct {
+		hash uint64
+		node string
+	}
+	var ring []point
+	for node, weight := range weights {
+		for i := 0; i < weight*hashReplicas; i++ {
+			ring = append(ring, point{hash64(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+		return ring[i].node < ring[j].node
+	})
+
+	return func(r *http.Request) {
+		if len(ring) == 0 {
+			return
+		}
+		h := hash64(key(r))
+		i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+		if i == len(ring) {
+			i = 0
+		}
+		r.URL.Host = ring[i].node
+	}
+}
+
+// hash64 returns the FNV-1a hash of s, mixed with the finalizer of
+// MurmurHash3 so that similar strings, like the points of a node, spread
+// over the whole ring.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
 // Decorate decorates a Client c with all the given Decorators, in order.
+// Each Decorator wraps the result of the previous ones, so the first Decorator
+// is the innermost: a request passes through the Decorators from last to first
+// before reaching c. The layers of the result can be walked by helpers like
+// Shutdown and ChainHealth.
 func Decorate(c Client, ds ...Decorator) Client {
 	decorated := c
 	for _, decorate := range ds {
-		decorated = decorate(decorated)
+		decorated = &layer{decorated: decorate(decorated), next: decorated}
+	}
+	return decorated
+}
+
+// ApplyReverse decorates a Client c with all the given Decorators in reverse
+// order, so the first Decorator is the outermost: a request passes through the
+// Decorators from first to last before reaching c.
+func ApplyReverse(c Client, ds ...Decorator) Client {
+	decorated := c
+	for i := len(ds) - 1; i >= 0; i-- {
+		decorated = &layer{decorated: ds[i](decorated), next: decorated}
 	}
 	return decorated
 }
 
-func decoratorMain() {
+// If returns a Decorator that applies d only to the requests for which pred
+// returns true. Any other request is passed through to the Client unchanged.
+func If(pred func(*http.Request) bool, d Decorator) Decorator {
+	return func(c Client) Client {
+		decorated := d(c)
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if pred(r) {
+				return decorated.Do(r)
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+func main() {
 	cli := Decorate(http.DefaultClient,
 		Authorization("authorizationtokengoeshere"),
 		LoadBalancing(RoundRobin(0, "web01", "web02", "web03")),
@@ -139,4 +338,13 @@ func decoratorMain() {
 		),
 		FaultTolerance(5, time.Second),
 	)
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := cli.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res.Body.Close()
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tag returns a Decorator that appends name to the X-Order header of every
+// request it passes on.
+func tag(name string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Add("X-Order", name)
+			return c.Do(r)
+		})
+	}
+}
+
+// order returns a Client that answers with the X-Order values of the request.
+func order(got *[]string) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		*got = r.Header.Values("X-Order")
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+}
+
+func TestDecorateOrder(t *testing.T) {
+	var got []string
+	c := Decorate(order(&got), tag("first"), tag("second"), tag("third"))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Decorate: request passed through %v, want %v", got, want)
+	}
+
+	c = ApplyReverse(order(&got), tag("first"), tag("second"), tag("third"))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ApplyReverse: request passed through %v, want %v", got, want)
+	}
+}
+
+func TestIf(t *testing.T) {
+	var got []string
+	isPost := func(r *http.Request) bool { return r.Method == http.MethodPost }
+	c := Decorate(order(&got), If(isPost, tag("post-only")))
+
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", nil)).Body.Close()
+	if want := []string{"post-only"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("POST: got %v, want %v", got, want)
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if len(got) != 0 {
+		t.Fatalf("GET: got %v, want the Decorator skipped", got)
+	}
+}
+
+// flaky returns a Client that fails its first failures requests with
+// errFlaky and counts the requests it gets in calls.
+func flaky(failures int, calls *int) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		*calls++
+		if *calls <= failures {
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+}
+
+var errFlaky = errors.New("flaky")
+
+func TestFaultToleranceCapped(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	c := Decorate(flaky(4, &calls), FaultToleranceCapped(5, time.Second, 2500*time.Millisecond), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+
+	want := []time.Duration{time.Second, 2 * time.Second, 2500 * time.Millisecond, 2500 * time.Millisecond}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+	if calls != 5 {
+		t.Fatalf("calls = %d, want 5", calls)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	var got http.Header
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header.Clone()
+		return newResponse(r, http.StatusOK, ""), nil
+	}), Authorization("Bearer inner"), Authorization("Bearer outer"))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if values := got.Values("Authorization"); !reflect.DeepEqual(values, []string{"Bearer inner"}) {
+		t.Fatalf("Authorization = %q, want only the innermost token", values)
+	}
+}
+
+func TestShuffleBackends(t *testing.T) {
+	direct := ShuffleBackends(1, "a.example", "b.example", "c.example")
+	var mu sync.Mutex
+	counts := map[string]int{}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 750; i++ {
+				r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+				direct(r)
+				mu.Lock()
+				counts[r.URL.Host]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		if counts[host] < 800 || counts[host] > 1200 {
+			t.Fatalf("picked %v, want about 1000 of each backend", counts)
+		}
+	}
+
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	ShuffleBackends(1)(r)
+	if r.URL.Host != "example.com" {
+		t.Fatalf("without backends, host = %q, want it unchanged", r.URL.Host)
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	weights := map[string]int{"a.example": 3, "b.example": 1, "c.example": 0}
+	picks := func(seed int64) []string {
+		direct := WeightedRandom(seed, weights)
+		var hosts []string
+		for i := 0; i < 4000; i++ {
+			r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+			direct(r)
+			hosts = append(hosts, r.URL.Host)
+		}
+		return hosts
+	}
+
+	hosts := picks(1)
+	counts := map[string]int{}
+	for _, host := range hosts {
+		counts[host]++
+	}
+	if counts["c.example"] != 0 || counts["a.example"] < 2800 || counts["a.example"] > 3200 || counts["a.example"]+counts["b.example"] != 4000 {
+		t.Fatalf("picked %v, want about 3000 a.example, 1000 b.example and no c.example", counts)
+	}
+	if !reflect.DeepEqual(picks(1), hosts) {
+		t.Fatal("the same seed yielded different picks")
+	}
+
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	WeightedRandom(1, map[string]int{"a.example": 0})(r)
+	if r.URL.Host != "example.com" {
+		t.Fatalf("without positive weights, host = %q, want it unchanged", r.URL.Host)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	key := func(r *http.Request) string { return r.URL.Path }
+	route := func(direct Director, path string) string {
+		r := newRequest(t, http.MethodGet, "http://example.com"+path, nil)
+		direct(r)
+		return r.URL.Host
+	}
+	all := ConsistentHash(key, map[string]int{"a": 1, "b": 1, "c": 2})
+	without := ConsistentHash(key, map[string]int{"a": 1, "b": 1, "c": 2, "d": 0})
+	fewer := ConsistentHash(key, map[string]int{"a": 1, "c": 2})
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		path := "/" + strconv.Itoa(i)
+		node := route(all, path)
+		counts[node]++
+		if again := route(all, path); again != node {
+			t.Fatalf("%s went to %s, then %s", path, node, again)
+		}
+		if other := route(without, path); other != node {
+			t.Fatalf("%s went to %s with a zero-weight node, want %s", path, other, node)
+		}
+		if moved := route(fewer, path); node != "b" && moved != node {
+			t.Fatalf("removing b moved %s from %s to %s", path, node, moved)
+		}
+	}
+	if counts["c"] < 1600 || counts["c"] > 2400 || counts["d"] != 0 {
+		t.Fatalf("keys per node = %v, want about half on c", counts)
+	}
+}
+
+func TestByContentType(t *testing.T) {
+	direct := ByContentType(map[string]string{
+		"multipart/form-data": "uploads",
+		"image/*":             "images",
+	}, "api")
+	for contentType, want := range map[string]string{
+		"multipart/form-data; boundary=x": "uploads",
+		"image/png":                       "images",
+		"application/json":                "api",
+		"multipart/mixed":                 "api",
+		"":                                "api",
+	} {
+		r := newRequest(t, http.MethodPost, "http://example.com/", nil)
+		r.Header.Set("Content-Type", contentType)
+		direct(r)
+		if r.URL.Host != want {
+			t.Errorf("%q went to %s, want %s", contentType, r.URL.Host, want)
+		}
+	}
+}
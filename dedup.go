@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// A RecordedResponse is the part of a response a DedupStore keeps.
+type RecordedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// A DedupStore keeps the responses to completed requests by idempotency key,
+// for an ExactlyOnce Decorator. Implementations backed by shared storage
+// extend the guarantee across processes.
+type DedupStore interface {
+	Load(key string) (RecordedResponse, bool)
+	Store(key string, res RecordedResponse)
+}
+
+// MemoryDedupStore is the in-memory DedupStore returned by
+// NewMemoryDedupStore. It keeps every response for the life of the process.
+type MemoryDedupStore struct {
+	mu        sync.Mutex
+	responses map[string]RecordedResponse
+}
+
+// NewMemoryDedupStore returns an empty MemoryDedupStore.
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{responses: map[string]RecordedResponse{}}
+}
+
+// Load returns the response stored under key, if any.
+func (s *MemoryDedupStore) Load(key string) (RecordedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.responses[key]
+	return res, ok
+}
+
+// Store keeps res under key.
+func (s *MemoryDedupStore) Store(key string, res RecordedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[key] = res
+}
+
+// ExactlyOnce returns a Decorator that sends every request carrying an
+// IdempotencyKeyHeader at most once to completion: the response to the first
+// one with a given method, URL, key and credentials, i.e. Authorization,
+// Proxy-Authorization and Cookie headers, is recorded in store, and later
+// ones get a copy of it without calling the Client. The credentials are part
+// of the store keys only as a hash. Errors and 5xx responses
+// aren't recorded, so those requests can be sent again. Combine it with
+// CoalesceByIdempotencyKey to also collapse duplicates sent concurrently.
+func ExactlyOnce(store DedupStore) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return c.Do(r)
+			}
+			credentials := sha256.Sum256([]byte(headerKey(r.Header, credentialHeaders)))
+			key = r.Method + " " + r.URL.String() + " " + key + " " + hex.EncodeToString(credentials[:])
+			if rec, ok := store.Load(key); ok {
+				return rec.response(r), nil
+			}
+			res, err := c.Do(r)
+			if err != nil || res.StatusCode >= 500 {
+				return res, err
+			}
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("recording response: %w", err)
+			}
+			store.Store(key, RecordedResponse{StatusCode: res.StatusCode, Header: res.Header.Clone(), Body: body})
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			return res, nil
+		})
+	}
+}
+
+// response returns a new response to r built from rec.
+func (rec RecordedResponse) response(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.StatusCode, http.StatusText(rec.StatusCode)),
+		StatusCode:    rec.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(rec.Body)),
+		ContentLength: int64(len(rec.Body)),
+		Request:       r,
+	}
+}
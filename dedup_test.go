@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExactlyOnce(t *testing.T) {
+	var calls int
+	status := http.StatusCreated
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		res := newResponse(r, status, "order 1")
+		res.Header.Set("Location", "/orders/1")
+		return res, nil
+	}), ExactlyOnce(NewMemoryDedupStore()))
+	post := func(key string) *http.Response {
+		r := newRequest(t, http.MethodPost, "http://example.com/orders", nil)
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		return do(t, c, r)
+	}
+
+	for i := 0; i < 2; i++ {
+		res := post("k1")
+		if got := bodyString(t, res); res.StatusCode != http.StatusCreated || got != "order 1" || res.Header.Get("Location") != "/orders/1" {
+			t.Fatalf("response %d = %d %q at %q, want the recorded one", i, res.StatusCode, got, res.Header.Get("Location"))
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("sent %d requests with the same key, want 1", calls)
+	}
+
+	post("").Body.Close()
+	post("").Body.Close()
+	if calls != 3 {
+		t.Fatalf("sent %d requests, want every one without a key sent", calls)
+	}
+
+	status = http.StatusServiceUnavailable
+	post("k2").Body.Close()
+	status = http.StatusCreated
+	post("k2").Body.Close()
+	post("k2").Body.Close()
+	if calls != 5 {
+		t.Fatalf("sent %d requests, want a 5xx response not recorded", calls)
+	}
+}
+
+func TestExactlyOnceCredentials(t *testing.T) {
+	var calls int
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return newResponse(r, http.StatusCreated, "order for "+r.Header.Get("Authorization")), nil
+	}), ExactlyOnce(NewMemoryDedupStore()))
+	for _, user := range []string{"alice", "bob", "alice"} {
+		r := newRequest(t, http.MethodPost, "http://example.com/orders", nil)
+		r.Header.Set(IdempotencyKeyHeader, "k1")
+		r.Header.Set("Authorization", user)
+		if got := bodyString(t, do(t, c, r)); got != "order for "+user {
+			t.Fatalf("%s got %q, want their own response", user, got)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("sent %d requests, want one per user", calls)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A GRPCError is returned in place of a gRPC-Web response with a non-OK
+// grpc-status.
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *GRPCError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("grpc status %d", e.Code)
+	}
+	return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message)
+}
+
+// GRPCWebStatus returns a Decorator that reads the trailers of every binary
+// gRPC-Web response, which come framed at the end of its body, into the
+// response's Trailer, and turns responses whose grpc-status isn't OK into a
+// *GRPCError. The body of the responses returned keeps only the message
+// frames. Trailers-only responses, which carry the status in their headers,
+// are handled too.
+func GRPCWebStatus() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil || !strings.HasPrefix(res.Header.Get("Content-Type"), "application/grpc-web") ||
+				strings.HasPrefix(res.Header.Get("Content-Type"), "application/grpc-web-text") {
+				return res, err
+			}
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			messages, trailer, err := splitGRPCWebFrames(body)
+			if err != nil {
+				return nil, err
+			}
+			if trailer == nil {
+				trailer = http.Header{}
+			}
+			for _, name := range []string{"Grpc-Status", "Grpc-Message"} {
+				if v := res.Header.Get(name); v != "" && trailer.Get(name) == "" {
+					trailer.Set(name, v)
+				}
+			}
+			res.Trailer = trailer
+			res.Body = io.NopCloser(bytes.NewReader(messages))
+			res.ContentLength = int64(len(messages))
+
+			status := trailer.Get("Grpc-Status")
+			if status == "" || status == "0" {
+				return res, nil
+			}
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				return nil, fmt.Errorf("invalid grpc-status %q", status)
+			}
+			msg, err := url.PathUnescape(trailer.Get("Grpc-Message"))
+			if err != nil {
+				msg = trailer.Get("Grpc-Message")
+			}
+			return nil, &GRPCError{Code: code, Message: msg}
+		})
+	}
+}
+
+// splitGRPCWebFrames separates the message frames of a gRPC-Web body from its
+// trailer frame, parsing the latter.
+func splitGRPCWebFrames(body []byte) (messages []byte, trailer http.Header, err error) {
+	for rest := body; len(rest) > 0; {
+		if len(rest) < 5 {
+			return nil, nil, errors.New("truncated gRPC-Web frame header")
+		}
+		size := binary.BigEndian.Uint32(rest[1:5])
+		if uint64(len(rest)-5) < uint64(size) {
+			return nil, nil, errors.New("truncated gRPC-Web frame")
+		}
+		frame := rest[:5+size]
+		rest = rest[5+size:]
+		if frame[0]&0x80 == 0 {
+			messages = append(messages, frame...)
+			continue
+		}
+		// The trailers are HTTP/1-style header lines, without a blank line
+		// after.
+		tp := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(frame[5:]), strings.NewReader("\r\n"))))
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing gRPC-Web trailers: %w", err)
+		}
+		trailer = http.Header(header)
+	}
+	return messages, trailer, nil
+}
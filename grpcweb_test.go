@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// grpcWebFrame returns a gRPC-Web frame with the given flags and payload.
+func grpcWebFrame(flags byte, payload string) string {
+	header := make([]byte, 5)
+	header[0] = flags
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	return string(header) + payload
+}
+
+// grpcWebResponse returns a Client that answers every request with a gRPC-Web
+// response with the given body and headers.
+func grpcWebResponse(body string, header ...string) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, body)
+		res.Header.Set("Content-Type", "application/grpc-web+proto")
+		for i := 0; i+1 < len(header); i += 2 {
+			res.Header.Set(header[i], header[i+1])
+		}
+		return res, nil
+	})
+}
+
+func TestGRPCWebStatus(t *testing.T) {
+	message := grpcWebFrame(0, "hello")
+	c := Decorate(grpcWebResponse(message+grpcWebFrame(0x80, "grpc-status: 0\r\nx-extra: 1\r\n")), GRPCWebStatus())
+	res := do(t, c, newRequest(t, http.MethodPost, "http://example.com/Svc/Method", nil))
+	if got := bodyString(t, res); got != message {
+		t.Fatalf("got body %q, want only the message frame", got)
+	}
+	if res.Trailer.Get("X-Extra") != "1" {
+		t.Fatalf("Trailer = %v, want the trailer frame's", res.Trailer)
+	}
+
+	c = Decorate(grpcWebResponse(message+grpcWebFrame(0x80, "grpc-status: 5\r\ngrpc-message: not%20found\r\n")), GRPCWebStatus())
+	_, err := c.Do(newRequest(t, http.MethodPost, "http://example.com/Svc/Method", nil))
+	var grpcErr *GRPCError
+	if !errors.As(err, &grpcErr) || grpcErr.Code != 5 || grpcErr.Message != "not found" {
+		t.Fatalf("Do() error = %v, want grpc status 5: not found", err)
+	}
+
+	// Trailers-only responses carry the status in their headers.
+	c = Decorate(grpcWebResponse("", "Grpc-Status", "14"), GRPCWebStatus())
+	if _, err := c.Do(newRequest(t, http.MethodPost, "http://example.com/Svc/Method", nil)); !errors.As(err, &grpcErr) || grpcErr.Code != 14 {
+		t.Fatalf("trailers-only: Do() error = %v, want grpc status 14", err)
+	}
+
+	c = Decorate(grpcWebResponse(message[:7]), GRPCWebStatus())
+	if _, err := c.Do(newRequest(t, http.MethodPost, "http://example.com/Svc/Method", nil)); err == nil {
+		t.Fatal("truncated frame: got no error")
+	}
+
+	c = Decorate(respond(http.StatusOK, "plain"), GRPCWebStatus())
+	res = do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if body := bodyString(t, res); body != "plain" {
+		t.Fatalf("non-gRPC-Web body = %q, want it untouched", body)
+	}
+}
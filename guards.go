@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrKillSwitch is returned by a KillSwitch Decorator while its switch is engaged.
+var ErrKillSwitch = errors.New("kill switch engaged")
+
+// KillSwitch returns a Decorator that fails every request with ErrKillSwitch,
+// without calling the Client, while engaged is set.
+func KillSwitch(engaged *atomic.Bool) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if engaged.Load() {
+				return nil, ErrKillSwitch
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// DisabledWhen returns a Decorator that answers every request with a fresh
+// response with the status, headers and body of the given fallback response,
+// without calling the Client, while off reports the dependency behind it as
+// disabled. The fallback body is read once, when the Decorator is created.
+// Requests fail while off reports true if fallback is nil.
+func DisabledWhen(off func() bool, fallback *http.Response) Decorator {
+	rec, err := recordFallback(fallback)
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if !off() {
+				return c.Do(r)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return rec.response(r), nil
+		})
+	}
+}
+
+// recordFallback records the status, headers and body of the fallback
+// response of a DisabledWhen Decorator, reading and closing its body.
+func recordFallback(fallback *http.Response) (RecordedResponse, error) {
+	if fallback == nil {
+		return RecordedResponse{}, errors.New("nil fallback response")
+	}
+	rec := RecordedResponse{StatusCode: fallback.StatusCode, Header: fallback.Header.Clone()}
+	if fallback.Body != nil {
+		body, err := io.ReadAll(fallback.Body)
+		fallback.Body.Close()
+		if err != nil {
+			return RecordedResponse{}, fmt.Errorf("reading fallback response: %w", err)
+		}
+		rec.Body = body
+	}
+	return rec, nil
+}
+
+// StaticFallback returns a Decorator that answers requests the Client fails
+// with an error, timeouts included, with a 200 response whose body is the
+// contents of the file at path and whose Content-Type follows from its
+// extension.
+func StaticFallback(path string) Decorator {
+	return StaticFallbackWith(path, http.StatusOK, mime.TypeByExtension(filepath.Ext(path)))
+}
+
+// StaticFallbackWith returns a Decorator like StaticFallback whose responses
+// have the given status code and Content-Type. The file is read on every
+// fallback, so it can be updated while the Client is in use; if it can't be
+// read, the Client's error is returned along with the reading one.
+func StaticFallbackWith(path string, status int, contentType string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err == nil {
+				return res, nil
+			}
+			body, rerr := os.ReadFile(path)
+			if rerr != nil {
+				return nil, errors.Join(err, fmt.Errorf("reading static fallback: %w", rerr))
+			}
+			header := http.Header{}
+			if contentType != "" {
+				header.Set("Content-Type", contentType)
+			}
+			return &http.Response{
+				Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+				StatusCode:    status,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        header,
+				Body:          io.NopCloser(bytes.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       r,
+			}, nil
+		})
+	}
+}
+
+// FallbackChain returns a Decorator that sends every request to the Client,
+// then to each of the given fallback Clients in order, until one of them
+// returns neither an error nor a 5xx response, whose result is returned.
+// Every attempt is bounded by its own timeout, and gets the request body from
+// the start. If they all fail, the result of the last one is returned.
+// Request bodies of more than 10 MiB without a GetBody are only sent to the
+// Client, as they can't be sent again.
+func FallbackChain(timeout time.Duration, fallbacks ...Client) Decorator {
+	return func(c Client) Client {
+		clients := append([]Client{c}, fallbacks...)
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			chain := clients
+			if r.GetBody == nil {
+				if _, _, err := peekBody(r, maxBufferedBody); err != nil {
+					return nil, err
+				}
+				if r.GetBody == nil && r.Body != nil && r.Body != http.NoBody {
+					chain = clients[:1]
+				}
+			}
+			var (
+				res *http.Response
+				err error
+			)
+			for i, client := range chain {
+				if i > 0 {
+					if res != nil {
+						drainAndClose(res.Body)
+					}
+					if err := rewind(r); err != nil {
+						return nil, err
+					}
+				}
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				res, err = client.Do(r.WithContext(ctx))
+				if err != nil {
+					cancel()
+					if r.Context().Err() != nil {
+						return nil, err
+					}
+					continue
+				}
+				res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+				if res.StatusCode < 500 {
+					return res, nil
+				}
+			}
+			return res, err
+		})
+	}
+}
+
+// ErrMethodNotAllowed is returned by an AllowMethods Decorator for requests
+// with a method outside its allowlist.
+var ErrMethodNotAllowed = errors.New("method not allowed")
+
+// AllowMethods returns a Decorator that fails every request whose method
+// isn't one of the given ones with ErrMethodNotAllowed, without calling the
+// Client, e.g. to keep a read-only Client from ever writing.
+func AllowMethods(methods ...string) Decorator {
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			method := r.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			if !allowed[method] {
+				return nil, fmt.Errorf("%w: %s", ErrMethodNotAllowed, method)
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// ErrRequestTooLarge is returned by a LimitRequestBody Decorator for request
+// bodies larger than allowed.
+var ErrRequestTooLarge = errors.New("request body too large")
+
+// LimitRequestBody returns a Decorator that fails requests whose body is
+// larger than max bytes with ErrRequestTooLarge. Requests that declare a
+// larger Content-Length fail before being sent; those of unknown length fail
+// while being sent, once the body grows past max.
+func LimitRequestBody(max int64) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.ContentLength > max {
+				return nil, fmt.Errorf("%w: %d bytes, over %d", ErrRequestTooLarge, r.ContentLength, max)
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &limitedBody{ReadCloser: r.Body, remaining: max}
+				if getBody := r.GetBody; getBody != nil {
+					r.GetBody = func() (io.ReadCloser, error) {
+						body, err := getBody()
+						if err != nil {
+							return nil, err
+						}
+						return &limitedBody{ReadCloser: body, remaining: max}, nil
+					}
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// limitedBody is a request body that fails with ErrRequestTooLarge once more
+// than its remaining bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n, b.remaining = int(b.remaining), 0
+		return n, ErrRequestTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// ErrHeadersTooLarge is returned by a LimitHeaderBytes Decorator for requests
+// whose headers are larger than allowed.
+var ErrHeadersTooLarge = errors.New("request headers too large")
+
+// LimitHeaderBytes returns a Decorator that fails requests whose headers take
+// more than max bytes on the wire, counted as "Name: value\r\n" lines, with
+// ErrHeadersTooLarge before sending them.
+func LimitHeaderBytes(max int) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			size := 0
+			for name, values := range r.Header {
+				for _, v := range values {
+					size += len(name) + len(": ") + len(v) + len("\r\n")
+				}
+			}
+			if size > max {
+				return nil, fmt.Errorf("%w: %d bytes, over %d", ErrHeadersTooLarge, size, max)
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// ErrTooManyHeaders is returned by a LimitResponseHeaders Decorator for
+// responses with more headers than allowed.
+var ErrTooManyHeaders = errors.New("response has too many headers")
+
+// LimitResponseHeaders returns a Decorator that rejects responses carrying
+// more than max header lines, counting every value of a repeated header, with
+// ErrTooManyHeaders, closing their body.
+func LimitResponseHeaders(max int) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil {
+				return res, err
+			}
+			count := 0
+			for _, values := range res.Header {
+				count += len(values)
+			}
+			if count > max {
+				drainAndClose(res.Body)
+				return nil, fmt.Errorf("%w: %d, over %d", ErrTooManyHeaders, count, max)
+			}
+			return res, nil
+		})
+	}
+}
+
+// ErrShortRead is returned by the bodies of responses checked by an
+// EnforceContentLength Decorator when their length doesn't match their
+// Content-Length.
+var ErrShortRead = errors.New("response body length doesn't match Content-Length")
+
+// EnforceContentLength returns a Decorator that checks the body of every
+// response declaring a Content-Length against it: reading a truncated body
+// fails with ErrShortRead at its end, instead of the usual io.EOF, and so
+// does reading past the declared length.
+func EnforceContentLength() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil || res.ContentLength < 0 || res.Body == nil || res.Body == http.NoBody {
+				return res, err
+			}
+			res.Body = &checkedBody{ReadCloser: res.Body, remaining: res.ContentLength}
+			return res, nil
+		})
+	}
+}
+
+// checkedBody is a response body that fails with ErrShortRead if it ends
+// before, or goes on after, its remaining bytes are read.
+type checkedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *checkedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	switch {
+	case b.remaining < 0:
+		return n, fmt.Errorf("%w: %d bytes too many", ErrShortRead, -b.remaining)
+	case err == io.EOF && b.remaining > 0:
+		return n, fmt.Errorf("%w: %d bytes missing", ErrShortRead, b.remaining)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return n, fmt.Errorf("%w: %w", ErrShortRead, err)
+	}
+	return n, err
+}
+
+// APIVersionHeader is the response header in which versioned APIs give the
+// version of the API they serve.
+const APIVersionHeader = "API-Version"
+
+// ErrIncompatibleVersion is returned by a RequireAPIVersion Decorator for
+// responses from an API version outside its supported range.
+var ErrIncompatibleVersion = errors.New("incompatible API version")
+
+// RequireAPIVersion returns a Decorator that fails every response whose
+// APIVersionHeader holds a semantic version, e.g. "1.4.2" or "v2", outside
+// the range from min, included, to max, excluded, closing its body and
+// returning ErrIncompatibleVersion, so that a backend changing incompatibly
+// fails loudly. An empty max leaves the range open. Pre-release and build
+// suffixes are ignored. Responses without the header are returned as they are.
+func RequireAPIVersion(min, max string) Decorator {
+	lo, err := parseSemver(min)
+	hi, supported := lo, min+" and up"
+	if err == nil && max != "" {
+		hi, err = parseSemver(max)
+		supported = "from " + min + " to " + max
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if err != nil {
+				return nil, fmt.Errorf("parsing supported API versions: %w", err)
+			}
+			res, rerr := c.Do(r)
+			if rerr != nil || res.Header.Get(APIVersionHeader) == "" {
+				return res, rerr
+			}
+			version := res.Header.Get(APIVersionHeader)
+			v, perr := parseSemver(version)
+			if perr == nil && !v.less(lo) && (max == "" || v.less(hi)) {
+				return res, nil
+			}
+			res.Body.Close()
+			return nil, fmt.Errorf("%w: %s, supported %s", ErrIncompatibleVersion, version, supported)
+		})
+	}
+}
+
+// semver is a semantic version, without pre-release and build suffixes.
+type semver [3]int
+
+// parseSemver parses a version of up to three dot-separated numbers,
+// optionally prefixed with a v. Missing numbers are zeros.
+func parseSemver(s string) (semver, error) {
+	var v semver
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	core, _, _ = strings.Cut(core, "-")
+	parts := strings.Split(core, ".")
+	if len(parts) > len(v) {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// less reports whether v precedes w.
+func (v semver) less(w semver) bool {
+	for i := range v {
+		if v[i] != w[i] {
+			return v[i] < w[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKillSwitch(t *testing.T) {
+	var engaged atomic.Bool
+	c := Decorate(respond(http.StatusOK, ""), KillSwitch(&engaged))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+
+	engaged.Store(true)
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != ErrKillSwitch {
+		t.Fatalf("engaged: Do() error = %v, want %v", err, ErrKillSwitch)
+	}
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	}), LimitRequestBody(4))
+
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("1234"))).Body.Close()
+	if _, err := c.Do(newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("12345"))); !errors.Is(err, ErrRequestTooLarge) {
+		t.Fatalf("declared length: Do() error = %v, want %v", err, ErrRequestTooLarge)
+	}
+	r := newRequest(t, http.MethodPost, "http://example.com/", io.MultiReader(strings.NewReader("12345")))
+	if _, err := c.Do(r); !errors.Is(err, ErrRequestTooLarge) {
+		t.Fatalf("unknown length: Do() error = %v, want %v", err, ErrRequestTooLarge)
+	}
+}
+
+func TestLimitHeaderBytes(t *testing.T) {
+	c := Decorate(respond(http.StatusOK, ""), LimitHeaderBytes(len("X-A: 12\r\n")))
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("X-A", "12")
+	do(t, c, r).Body.Close()
+
+	r.Header.Set("X-A", "123")
+	if _, err := c.Do(r); !errors.Is(err, ErrHeadersTooLarge) {
+		t.Fatalf("Do() error = %v, want %v", err, ErrHeadersTooLarge)
+	}
+}
+
+func TestDisabledWhen(t *testing.T) {
+	var off bool
+	fallback := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"60"}}, Body: io.NopCloser(strings.NewReader("down"))}
+	c := Decorate(respond(http.StatusOK, "up"), DisabledWhen(func() bool { return off }, fallback))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "up" {
+		t.Fatalf("while enabled, got %q, want the Client's response", got)
+	}
+
+	off = true
+	for i := 0; i < 2; i++ {
+		r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+		res := do(t, c, r)
+		if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") != "60" || res.Request != r {
+			t.Fatalf("while disabled, got %d %v, want the fallback status and headers", res.StatusCode, res.Header)
+		}
+		res.Header.Set("Retry-After", "0")
+		if got := bodyString(t, res); got != "down" {
+			t.Fatalf("while disabled, got %q, want the fallback body", got)
+		}
+	}
+	if fallback.Header.Get("Retry-After") != "60" {
+		t.Fatal("the fallback response was modified")
+	}
+
+	c = Decorate(respond(http.StatusOK, "up"), DisabledWhen(func() bool { return true }, nil))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err == nil {
+		t.Fatal("with a nil fallback, got no error")
+	}
+}
+
+func TestStaticFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fallback.json")
+	if err := os.WriteFile(path, []byte(`{"items":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fail := ClientFunc(func(r *http.Request) (*http.Response, error) { return nil, errFlaky })
+
+	res := do(t, Decorate(fail, StaticFallback(path)), newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d with Content-Type %q, want a 200 JSON response", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if got := bodyString(t, res); got != `{"items":[]}` {
+		t.Fatalf("got body %q, want the file contents", got)
+	}
+
+	c := Decorate(respond(http.StatusOK, "live"), StaticFallback(path))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "live" {
+		t.Fatalf("got body %q, want the Client's response", got)
+	}
+
+	res = do(t, Decorate(fail, StaticFallbackWith(path, http.StatusServiceUnavailable, "text/plain")), newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("got %d with Content-Type %q, want a 503 text/plain response", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	res.Body.Close()
+
+	_, err := Decorate(fail, StaticFallback(path+".missing")).Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if !errors.Is(err, errFlaky) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("with a missing file, Do() error = %v, want both errors", err)
+	}
+}
+
+func TestEnforceContentLength(t *testing.T) {
+	for _, tc := range []struct {
+		body          string
+		contentLength int64
+		wantErr       bool
+	}{
+		{"hello", 5, false},
+		{"hello", -1, false},
+		{"hell", 5, true},
+		{"hello!", 5, true},
+	} {
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res := newResponse(r, http.StatusOK, tc.body)
+			res.ContentLength = tc.contentLength
+			return res, nil
+		}), EnforceContentLength())
+		res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+		_, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if got := errors.Is(err, ErrShortRead); got != tc.wantErr {
+			t.Errorf("%q with Content-Length %d: read error = %v, want ErrShortRead: %v", tc.body, tc.contentLength, err, tc.wantErr)
+		}
+	}
+}
+
+func TestLimitResponseHeaders(t *testing.T) {
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, "")
+		res.Header["Set-Cookie"] = []string{"a=1", "b=2"}
+		res.Header.Set("Content-Type", "text/plain")
+		return res, nil
+	}), LimitResponseHeaders(3))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+
+	c = Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, "")
+		res.Header["Set-Cookie"] = []string{"a=1", "b=2", "c=3"}
+		res.Header.Set("Content-Type", "text/plain")
+		return res, nil
+	}), LimitResponseHeaders(3))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); !errors.Is(err, ErrTooManyHeaders) {
+		t.Fatalf("4 header lines: Do() error = %v, want %v", err, ErrTooManyHeaders)
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	var sent []string
+	backend := func(name string, status int, err error) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			var body []byte
+			if r.Body != nil {
+				body, _ = io.ReadAll(r.Body)
+			}
+			sent = append(sent, name+":"+string(body))
+			if err != nil {
+				return nil, err
+			}
+			return newResponse(r, status, name), nil
+		})
+	}
+	hanging := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		sent = append(sent, "hanging")
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+
+	c := Decorate(backend("primary", http.StatusServiceUnavailable, nil), FallbackChain(time.Second, backend("first", 0, errFlaky), backend("second", http.StatusOK, nil)))
+	res := do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable("payload")))
+	if got := bodyString(t, res); got != "second" {
+		t.Fatalf("got the response of %s, want second", got)
+	}
+	if want := []string{"primary:payload", "first:payload", "second:payload"}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent %v, want %v", sent, want)
+	}
+
+	sent = nil
+	c = Decorate(hanging, FallbackChain(10*time.Millisecond, backend("fallback", http.StatusOK, nil)))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "fallback" {
+		t.Fatalf("got the response of %s, want the fallback after the timeout", got)
+	}
+
+	c = Decorate(backend("primary", http.StatusBadGateway, nil), FallbackChain(time.Second, backend("last", http.StatusServiceUnavailable, nil)))
+	if res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)); res.StatusCode != http.StatusServiceUnavailable || bodyString(t, res) != "last" {
+		t.Fatalf("with every Client failing, got %d, want the last 503", res.StatusCode)
+	}
+	sent = nil
+	c = Decorate(backend("primary", http.StatusBadGateway, nil), FallbackChain(time.Second, backend("fallback", http.StatusOK, nil)))
+	large := strings.Repeat("x", maxBufferedBody+1)
+	if res := do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable(large))); res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("with a body too large to resend, got %d, want the primary's 502", res.StatusCode)
+	}
+	if len(sent) != 1 || sent[0] != "primary:"+large {
+		t.Fatalf("sent to %d Clients, want the whole body sent to the primary only", len(sent))
+	}
+
+	sent = nil
+	r := newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader(large))
+	do(t, c, r).Body.Close()
+	if len(sent) != 2 {
+		t.Fatalf("sent to %d Clients, want a body with GetBody resent", len(sent))
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	c := Decorate(respond(http.StatusOK, ""), AllowMethods(http.MethodGet, http.MethodHead))
+	for method, allowed := range map[string]bool{
+		http.MethodGet:    true,
+		"":                true,
+		http.MethodHead:   true,
+		http.MethodPost:   false,
+		http.MethodDelete: false,
+	} {
+		r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+		r.Method = method
+		res, err := c.Do(r)
+		if allowed && err != nil || !allowed && !errors.Is(err, ErrMethodNotAllowed) {
+			t.Errorf("%q: Do() error = %v, want allowed: %v", method, err, allowed)
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+func TestRequireAPIVersion(t *testing.T) {
+	version := ""
+	versioned := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, "")
+		if version != "" {
+			res.Header.Set(APIVersionHeader, version)
+		}
+		return res, nil
+	})
+	c := Decorate(versioned, RequireAPIVersion("1.4", "2"))
+	for v, ok := range map[string]bool{
+		"":            true,
+		"1.4.0":       true,
+		"v1.9.3-beta": true,
+		"1.3.9":       false,
+		"2.0.0":       false,
+		"two":         false,
+	} {
+		version = v
+		res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+		if ok && err != nil || !ok && !errors.Is(err, ErrIncompatibleVersion) {
+			t.Errorf("version %q: Do() error = %v, want compatible %v", v, err, ok)
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+
+	version = "42.0"
+	if _, err := Decorate(versioned, RequireAPIVersion("9", "")).Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != nil {
+		t.Fatalf("with an open range, Do() error = %v", err)
+	}
+	if _, err := Decorate(respond(http.StatusOK, ""), RequireAPIVersion("x.y", "")).Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err == nil {
+		t.Fatal("with an invalid min, Do() succeeded, want an error")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A HAR records the requests sent through its Decorator and their responses
+// as HTTP Archive (HAR 1.2) entries, e.g. to load them into browser tooling
+// for debugging. It keeps the latest entries until Reset. The values of
+// credential headers, like Authorization and Cookie, are redacted unless
+// Unredact says otherwise.
+type HAR struct {
+	maxBody    int
+	maxEntries int
+	redacted   map[string]bool
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// NewHAR returns a HAR that keeps up to maxBody bytes of every request and
+// response body, and the latest maxEntries entries.
+func NewHAR(maxBody, maxEntries int) *HAR {
+	return &HAR{
+		maxBody:    maxBody,
+		maxEntries: maxEntries,
+		redacted: map[string]bool{
+			"Authorization":       true,
+			"Proxy-Authorization": true,
+			"Cookie":              true,
+			"Set-Cookie":          true,
+		},
+	}
+}
+
+// harRedacted replaces the values of redacted headers in HAR entries.
+const harRedacted = "[REDACTED]"
+
+// Unredact makes h record the values of the given headers, which it redacts
+// by default. It returns h, and must be called before h's Decorator is in
+// use.
+func (h *HAR) Unredact(names ...string) *HAR {
+	for _, name := range names {
+		delete(h.redacted, http.CanonicalHeaderKey(name))
+	}
+	return h
+}
+
+// Decorator returns a Decorator that records every request a Client sends,
+// along with its response, in h. Request and response bodies are left
+// readable in full, and response bodies aren't recorded at all for streaming
+// requests. Recorded bodies are held within the MemoryBudget in the request
+// context, if any, until their entry is dropped or Reset, and those that
+// don't fit aren't recorded.
+func (h *HAR) Decorator() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			budget, _ := r.Context().Value(memoryBudgetKey).(*MemoryBudget)
+			e := harEntry{
+				Started: time.Now(),
+				Request: harRequest{
+					Method:      r.Method,
+					URL:         r.URL.String(),
+					HTTPVersion: r.Proto,
+					Headers:     h.headers(r.Header),
+					QueryString: []harPair{},
+					Cookies:     []harPair{},
+					HeadersSize: -1,
+					BodySize:    harBodySize(r.Body, r.ContentLength),
+				},
+				Cache:  struct{}{},
+				budget: budget,
+			}
+			var body []byte
+			if e.hold(h.maxBody) {
+				var err error
+				body, err = h.requestBody(r)
+				e.unhold(h.maxBody - len(body))
+				if err != nil {
+					return nil, err
+				}
+			}
+			for name, values := range r.URL.Query() {
+				for _, v := range values {
+					e.Request.QueryString = append(e.Request.QueryString, harPair{name, v})
+				}
+			}
+			if len(body) > 0 {
+				e.Request.PostData = &harPostData{MimeType: r.Header.Get("Content-Type"), Text: string(body)}
+			}
+
+			res, err := c.Do(r)
+			wait := time.Since(e.Started)
+			e.Time = float64(wait) / float64(time.Millisecond)
+			e.Timings = harTimings{Wait: e.Time}
+			if err != nil {
+				e.Error = err.Error()
+				e.Response = harResponse{Headers: []harPair{}, Cookies: []harPair{}, HeadersSize: -1, BodySize: -1}
+				h.add(e)
+				return nil, err
+			}
+
+			var prefix []byte
+			if !IsStreaming(r) && e.hold(h.maxBody) {
+				var rerr error
+				prefix, rerr = io.ReadAll(io.LimitReader(res.Body, int64(h.maxBody)))
+				e.unhold(h.maxBody - len(prefix))
+				res.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(prefix), res.Body), Closer: res.Body}
+				if rerr != nil {
+					e.Error = rerr.Error()
+				}
+			}
+			e.Response = harResponse{
+				Status:      res.StatusCode,
+				StatusText:  http.StatusText(res.StatusCode),
+				HTTPVersion: res.Proto,
+				Headers:     h.headers(res.Header),
+				Cookies:     []harPair{},
+				Content: harContent{
+					Size:     int(res.ContentLength),
+					MimeType: res.Header.Get("Content-Type"),
+					Text:     string(prefix),
+				},
+				RedirectURL: res.Header.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    int(res.ContentLength),
+			}
+			h.add(e)
+			return res, nil
+		})
+	}
+}
+
+// MarshalJSON encodes the entries recorded in h as a HAR log.
+func (h *HAR) MarshalJSON() ([]byte, error) {
+	h.mu.Lock()
+	entries := append([]harEntry{}, h.entries...)
+	h.mu.Unlock()
+
+	var doc struct {
+		Log struct {
+			Version string     `json:"version"`
+			Creator harCreator `json:"creator"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	doc.Log.Version = "1.2"
+	doc.Log.Creator = harCreator{Name: "go-decorator", Version: "1.0"}
+	doc.Log.Entries = entries
+	return json.Marshal(doc)
+}
+
+// Reset discards the entries recorded in h.
+func (h *HAR) Reset() {
+	h.mu.Lock()
+	for _, e := range h.entries {
+		e.unhold(int(e.held))
+	}
+	h.entries = nil
+	h.mu.Unlock()
+}
+
+// add records e, dropping the oldest entry if h is full.
+func (h *HAR) add(e harEntry) {
+	if h.maxEntries <= 0 {
+		e.unhold(int(e.held))
+		return
+	}
+	h.mu.Lock()
+	if len(h.entries) == h.maxEntries {
+		h.entries[0].unhold(int(h.entries[0].held))
+		copy(h.entries, h.entries[1:])
+		h.entries = h.entries[:len(h.entries)-1]
+	}
+	h.entries = append(h.entries, e)
+	h.mu.Unlock()
+}
+
+// requestBody returns up to the first maxBody bytes of the body of r, read
+// from a copy when r has a GetBody, and leaves r with a body that reads the
+// same in full.
+func (h *HAR) requestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(io.LimitReader(body, int64(h.maxBody)))
+	}
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, int64(h.maxBody)))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(prefix), r.Body), Closer: r.Body}
+	return prefix, nil
+}
+
+// headers returns the HAR pairs of header, with the values of the headers
+// redacted by h replaced.
+func (h *HAR) headers(header http.Header) []harPair {
+	pairs := []harPair{}
+	for name, values := range header {
+		for _, v := range values {
+			if h.redacted[http.CanonicalHeaderKey(name)] {
+				v = harRedacted
+			}
+			pairs = append(pairs, harPair{name, v})
+		}
+	}
+	return pairs
+}
+
+// harBodySize returns the HAR size of a body with the given ContentLength,
+// -1 if unknown.
+func harBodySize(body io.ReadCloser, contentLength int64) int {
+	if body == nil || body == http.NoBody {
+		return 0
+	}
+	if contentLength <= 0 {
+		return -1
+	}
+	return int(contentLength)
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	Started  time.Time   `json:"startedDateTime"`
+	Time     float64     `json:"time"`
+	Request  harRequest  `json:"request"`
+	Response harResponse `json:"response"`
+	Cache    struct{}    `json:"cache"`
+	Timings  harTimings  `json:"timings"`
+	Error    string      `json:"_error,omitempty"`
+
+	// budget is the MemoryBudget the recorded bodies are held within, if
+	// any, and held the number of bytes they hold.
+	budget *MemoryBudget
+	held   int64
+}
+
+// hold takes n bytes from the budget of e, if any, for a body about to be
+// recorded, and reports whether they fit.
+func (e *harEntry) hold(n int) bool {
+	if e.budget == nil {
+		return true
+	}
+	if !e.budget.reserve(int64(n)) {
+		return false
+	}
+	e.held += int64(n)
+	return true
+}
+
+// unhold gives n of the bytes taken by hold back to the budget of e.
+func (e *harEntry) unhold(n int) {
+	if e.budget == nil {
+		return
+	}
+	e.budget.release(int64(n))
+	e.held -= int64(n)
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []harPair    `json:"headers"`
+	QueryString []harPair    `json:"queryString"`
+	Cookies     []harPair    `json:"cookies"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Headers     []harPair  `json:"headers"`
+	Cookies     []harPair  `json:"cookies"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int        `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// harLog is the part of a HAR log the tests look at.
+type harLog struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+func entriesOf(t *testing.T, h *HAR) []harEntry {
+	t.Helper()
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc harLog
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Log.Entries
+}
+
+func headerValue(pairs []harPair, name string) string {
+	for _, p := range pairs {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+func TestHAR(t *testing.T) {
+	var sent []string
+	h := NewHAR(4, 10)
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if _, err := bodies(0, &sent).Do(r); err != nil {
+			return nil, err
+		}
+		return newResponse(r, http.StatusCreated, "created"), nil
+	}), h.Decorator())
+
+	r := newRequest(t, http.MethodPost, "http://example.com/items?a=1", unseekable("payload"))
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("Authorization", "Bearer secret")
+	if got := bodyString(t, do(t, c, r)); got != "created" {
+		t.Fatalf("got body %q, want it in full", got)
+	}
+	if len(sent) != 1 || sent[0] != "payload" {
+		t.Fatalf("sent bodies %q, want the request body in full", sent)
+	}
+
+	entries := entriesOf(t, h)
+	if len(entries) != 1 {
+		t.Fatalf("recorded %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Request.Method != http.MethodPost || e.Request.URL != "http://example.com/items?a=1" || e.Request.PostData.Text != "payl" {
+		t.Fatalf("recorded request %+v, want the method, URL and truncated body", e.Request)
+	}
+	if got := headerValue(e.Request.Headers, "Authorization"); got != harRedacted {
+		t.Fatalf("recorded Authorization %q, want it redacted", got)
+	}
+	if e.Response.Status != http.StatusCreated || e.Response.Content.Text != "crea" {
+		t.Fatalf("recorded response %+v, want the status and truncated body", e.Response)
+	}
+}
+
+func TestHARUnredact(t *testing.T) {
+	h := NewHAR(0, 10).Unredact("authorization")
+	c := Decorate(respond(http.StatusOK, ""), h.Decorator())
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("Cookie", "session=1")
+	do(t, c, r).Body.Close()
+
+	headers := entriesOf(t, h)[0].Request.Headers
+	if got := headerValue(headers, "Authorization"); got != "Bearer token" {
+		t.Fatalf("recorded Authorization %q, want it as sent", got)
+	}
+	if got := headerValue(headers, "Cookie"); got != harRedacted {
+		t.Fatalf("recorded Cookie %q, want it redacted", got)
+	}
+}
+
+func TestHARKeepsLatestEntries(t *testing.T) {
+	h := NewHAR(0, 2)
+	c := Decorate(respond(http.StatusOK, ""), h.Decorator())
+	for i := 0; i < 5; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/"+strconv.Itoa(i), nil)).Body.Close()
+	}
+	var urls []string
+	for _, e := range entriesOf(t, h) {
+		urls = append(urls, e.Request.URL)
+	}
+	if got := strings.Join(urls, " "); got != "http://example.com/3 http://example.com/4" {
+		t.Fatalf("kept %s, want the latest 2 entries", got)
+	}
+}
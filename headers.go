@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Nonce returns a Decorator that sets a fresh random nonce and the current
+// Unix timestamp on every request, in the given headers. Place it inside any
+// retrying Decorator so that every attempt carries its own nonce.
+func Nonce(nonceHeader, tsHeader string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			nonce, err := randomID()
+			if err != nil {
+				return nil, err
+			}
+			r.Header.Set(nonceHeader, nonce)
+			r.Header.Set(tsHeader, strconv.FormatInt(time.Now().Unix(), 10))
+			return c.Do(r)
+		})
+	}
+}
+
+// randomID returns 16 random bytes, hex encoded.
+func randomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// ContextWithCorrelationID returns a copy of ctx carrying the given
+// correlation ID.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx, if any.
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey).(string)
+	return id, ok && id != ""
+}
+
+// CorrelationID returns a Decorator that sets the given header on every
+// request to the correlation ID of its context. Requests without one get a
+// fresh random ID, which is also put in the context seen by the Decorators
+// it wraps, so that the whole chain uses the same ID.
+func CorrelationID(header string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			id, ok := CorrelationIDFromContext(r.Context())
+			if !ok {
+				var err error
+				if id, err = randomID(); err != nil {
+					return nil, err
+				}
+				r = r.WithContext(ContextWithCorrelationID(r.Context(), id))
+			}
+			r.Header.Set(header, id)
+			return c.Do(r)
+		})
+	}
+}
+
+// WithCookieJar returns a Decorator that sends the cookies in jar with every
+// request and stores the cookies set by every response back into jar, keyed
+// by the request URL. A nil jar is replaced by an empty in-memory one.
+func WithCookieJar(jar http.CookieJar) Decorator {
+	if jar == nil {
+		jar, _ = cookiejar.New(nil)
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			// Cookies go on a copy so that retried requests don't accumulate them.
+			req := r.Clone(r.Context())
+			for _, cookie := range jar.Cookies(req.URL) {
+				req.AddCookie(cookie)
+			}
+			res, err := c.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			if cookies := res.Cookies(); len(cookies) > 0 {
+				jar.SetCookies(req.URL, cookies)
+			}
+			return res, nil
+		})
+	}
+}
+
+// hopByHopHeaders are the headers meaningful only for a single connection,
+// per RFC 7230 section 6.1, plus their common non-standard variants.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// StripHopByHop returns a Decorator that removes hop-by-hop headers, and any
+// header listed in the Connection header, from every request, as proxies must
+// before forwarding them.
+func StripHopByHop() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			for _, v := range r.Header.Values("Connection") {
+				for _, name := range strings.Split(v, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						r.Header.Del(name)
+					}
+				}
+			}
+			for _, name := range hopByHopHeaders {
+				r.Header.Del(name)
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// ContextWithBaggage returns a copy of ctx carrying the given W3C baggage
+// entries in addition to those already in ctx, which they override.
+func ContextWithBaggage(ctx context.Context, pairs map[string]string) context.Context {
+	merged := map[string]string{}
+	for k, v := range BaggageFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range pairs {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, baggageKey, merged)
+}
+
+// BaggageFromContext returns the W3C baggage entries carried by ctx.
+func BaggageFromContext(ctx context.Context) map[string]string {
+	pairs, _ := ctx.Value(baggageKey).(map[string]string)
+	return pairs
+}
+
+// Baggage returns a Decorator that sets the given entries, merged with those
+// of the request context, in the W3C baggage header of every request. Entries
+// from the context override the given ones, which override those already in
+// the header. Values are percent-encoded and entries are sorted by key.
+func Baggage(pairs map[string]string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			// Members already in the header are kept as they are, properties included.
+			members := map[string]string{}
+			for _, v := range r.Header.Values("Baggage") {
+				for _, member := range strings.Split(v, ",") {
+					if member = strings.TrimSpace(member); member != "" {
+						key, _, _ := strings.Cut(member, "=")
+						members[strings.TrimSpace(key)] = member
+					}
+				}
+			}
+			for k, v := range pairs {
+				members[k] = k + "=" + url.PathEscape(v)
+			}
+			for k, v := range BaggageFromContext(r.Context()) {
+				members[k] = k + "=" + url.PathEscape(v)
+			}
+
+			keys := make([]string, 0, len(members))
+			for k := range members {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			list := make([]string, len(keys))
+			for i, k := range keys {
+				list[i] = members[k]
+			}
+			r.Header.Set("Baggage", strings.Join(list, ","))
+			return c.Do(r)
+		})
+	}
+}
+
+// RotateAPIKeys returns a Decorator that sets the given header on every
+// request to the next of the given keys, round-robin.
+func RotateAPIKeys(header string, keys ...string) Decorator {
+	return func(c Client) Client {
+		var next uint64
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if len(keys) > 0 {
+				r.Header.Set(header, keys[(atomic.AddUint64(&next, 1)-1)%uint64(len(keys))])
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// RefreshAuthorization returns a Decorator that authorizes every request
+// with the current token, starting with the given one, like Authorization.
+// When a request is answered with 401 Unauthorized, refresh is called for a
+// new token, which becomes the current one, and the request is sent once
+// more with it. A request is retried at most once, so a token that keeps
+// being rejected can't cause a refresh loop, and only if its body can be
+// rewound with GetBody.
+func RefreshAuthorization(token string, refresh func(context.Context) (string, error)) Decorator {
+	var current atomic.Value
+	current.Store(token)
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set("Authorization", current.Load().(string))
+			res, err := c.Do(r)
+			if err != nil || res.StatusCode != http.StatusUnauthorized {
+				return res, err
+			}
+			if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+				return res, nil
+			}
+			drainAndClose(res.Body)
+			fresh, err := refresh(r.Context())
+			if err != nil {
+				return nil, fmt.Errorf("refreshing authorization: %w", err)
+			}
+			current.Store(fresh)
+			if err := rewind(r); err != nil {
+				return nil, err
+			}
+			r.Header.Set("Authorization", fresh)
+			return c.Do(r)
+		})
+	}
+}
+
+// Sequence returns a Decorator that numbers every request from a counter
+// that only goes up and sets the number in the given header, for backends
+// that require strictly increasing sequence numbers. The number is kept in
+// the request context. A request that already has one, in its context or in
+// the header, keeps it, so every attempt of a retried request carries the
+// same number wherever Sequence is in the chain.
+func Sequence(header string) Decorator {
+	var last uint64
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			n, ok := SequenceFromContext(r.Context())
+			if !ok {
+				var err error
+				if n, err = strconv.ParseUint(r.Header.Get(header), 10, 64); err != nil {
+					n = atomic.AddUint64(&last, 1)
+				}
+				r = r.WithContext(context.WithValue(r.Context(), sequenceKey, n))
+			}
+			r.Header.Set(header, strconv.FormatUint(n, 10))
+			return c.Do(r)
+		})
+	}
+}
+
+// SequenceFromContext returns the sequence number that a Sequence Decorator
+// gave the request with the given context, if any.
+func SequenceFromContext(ctx context.Context) (uint64, bool) {
+	n, ok := ctx.Value(sequenceKey).(uint64)
+	return n, ok
+}
+
+// Seed returns a Decorator that sets the given header on every request to the
+// seed derived from it by the given function, e.g. RequestSeed, so that a
+// cooperating server can behave deterministically. A function returning a
+// constant gives every request the same seed.
+func Seed(header string, seed func(*http.Request) string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set(header, seed(r))
+			return c.Do(r)
+		})
+	}
+}
+
+// RequestSeed is a seed function for Seed that hashes the method and URL of
+// a request, so that identical requests get identical seeds.
+func RequestSeed(r *http.Request) string {
+	return strconv.FormatUint(hash64(r.Method+" "+r.URL.String()), 10)
+}
+
+// ContextWithIfMatch returns a copy of ctx carrying the given entity tag for
+// an IfMatch Decorator to send.
+func ContextWithIfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey, etag)
+}
+
+// IfMatch returns a Decorator that makes every request conditional on the
+// entity tag carried by its context, see ContextWithIfMatch, or else returned
+// by the given function, if not nil, by setting it in the If-Match header. A
+// server then rejects updates to a resource changed since that version with
+// 412 Precondition Failed. Requests without an entity tag are sent as they
+// are.
+func IfMatch(etag func(*http.Request) string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			tag, _ := r.Context().Value(ifMatchKey).(string)
+			if tag == "" && etag != nil {
+				tag = etag(r)
+			}
+			if tag != "" {
+				r.Header.Set("If-Match", tag)
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// VersionHeaders returns a Decorator that identifies the calling client on
+// every request by setting the X-Client-Service, X-Client-Version and
+// X-Client-Commit headers to the given values, so that servers can tell which
+// build called them.
+func VersionHeaders(service, version, commit string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set("X-Client-Service", service)
+			r.Header.Set("X-Client-Version", version)
+			r.Header.Set("X-Client-Commit", commit)
+			return c.Do(r)
+		})
+	}
+}
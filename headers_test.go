@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// headersOf returns a Client that stores the headers of each request it gets
+// in got.
+func headersOf(got *http.Header) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		*got = r.Header.Clone()
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+}
+
+func TestNonce(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), Nonce("X-Nonce", "X-Timestamp"))
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+		nonce := got.Get("X-Nonce")
+		if len(nonce) != 32 || seen[nonce] {
+			t.Fatalf("X-Nonce = %q, want a fresh 32-digit hex nonce", nonce)
+		}
+		seen[nonce] = true
+		ts, err := strconv.ParseInt(got.Get("X-Timestamp"), 10, 64)
+		if err != nil || time.Since(time.Unix(ts, 0)).Abs() > time.Minute {
+			t.Fatalf("X-Timestamp = %q, want the current Unix time", got.Get("X-Timestamp"))
+		}
+	}
+}
+
+func TestWithCookieJar(t *testing.T) {
+	var got http.Header
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header.Clone()
+		res := newResponse(r, http.StatusOK, "")
+		res.Header.Add("Set-Cookie", "session=abc; Path=/")
+		return res, nil
+	}), WithCookieJar(nil))
+
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	do(t, c, r).Body.Close()
+	if cookie := got.Get("Cookie"); cookie != "" {
+		t.Fatalf("first request: Cookie = %q, want none", cookie)
+	}
+	do(t, c, r).Body.Close()
+	if cookie := got.Get("Cookie"); cookie != "session=abc" {
+		t.Fatalf("second request: Cookie = %q, want session=abc", cookie)
+	}
+	if cookie := r.Header.Get("Cookie"); cookie != "" {
+		t.Fatalf("caller's request: Cookie = %q, want it left alone", cookie)
+	}
+}
+
+func TestStripHopByHop(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), StripHopByHop())
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Connection", "keep-alive, X-Private")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("Proxy-Authorization", "Basic abc")
+	r.Header.Set("X-Private", "1")
+	r.Header.Set("Accept", "text/plain")
+	do(t, c, r).Body.Close()
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Authorization", "X-Private"} {
+		if v := got.Get(name); v != "" {
+			t.Errorf("%s = %q, want it stripped", name, v)
+		}
+	}
+	if v := got.Get("Accept"); v != "text/plain" {
+		t.Errorf("Accept = %q, want it kept", v)
+	}
+}
+
+func TestBaggage(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), Baggage(map[string]string{"tenant": "acme", "region": "eu west"}))
+	ctx := ContextWithBaggage(context.Background(), map[string]string{"tenant": "globex"})
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	r.Header.Set("Baggage", "user=alice;prop=1, region=us")
+	do(t, c, r).Body.Close()
+
+	if want := "region=eu%20west,tenant=globex,user=alice;prop=1"; got.Get("Baggage") != want {
+		t.Fatalf("Baggage = %q, want %q", got.Get("Baggage"), want)
+	}
+}
+
+func TestRotateAPIKeys(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), RotateAPIKeys("X-Api-Key", "k1", "k2", "k3"))
+	var keys []string
+	for i := 0; i < 4; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+		keys = append(keys, got.Get("X-Api-Key"))
+	}
+	if want := []string{"k1", "k2", "k3", "k1"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("sent keys %v, want %v", keys, want)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	var got http.Header
+	var inner string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header.Clone()
+		inner, _ = CorrelationIDFromContext(r.Context())
+		return newResponse(r, http.StatusOK, ""), nil
+	}), CorrelationID("X-Correlation-Id"))
+
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if id := got.Get("X-Correlation-Id"); len(id) != 32 || inner != id {
+		t.Fatalf("fresh ID: header %q and context %q, want the same random ID", id, inner)
+	}
+
+	ctx := ContextWithCorrelationID(context.Background(), "req-42")
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)).Body.Close()
+	if id := got.Get("X-Correlation-Id"); id != "req-42" {
+		t.Fatalf("context ID: header %q, want req-42", id)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), Seed("X-Seed", RequestSeed))
+	seed := func(method, url string) string {
+		do(t, c, newRequest(t, method, url, nil)).Body.Close()
+		return got.Get("X-Seed")
+	}
+	first, second := seed(http.MethodGet, "http://example.com/a"), seed(http.MethodGet, "http://example.com/a")
+	if first == "" || second != first {
+		t.Fatalf("identical requests got seeds %q and %q, want the same one", first, second)
+	}
+	if seed(http.MethodGet, "http://example.com/b") == first || seed(http.MethodPost, "http://example.com/a") == first {
+		t.Fatal("different requests got the same seed")
+	}
+
+	c = Decorate(headersOf(&got), Seed("X-Seed", func(*http.Request) string { return "42" }))
+	if got := seed(http.MethodGet, "http://example.com/b"); got != "42" {
+		t.Fatalf("constant seed = %q, want 42", got)
+	}
+}
+
+func TestRefreshAuthorization(t *testing.T) {
+	var seen []string
+	valid := "Bearer fresh"
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != valid {
+			return newResponse(r, http.StatusUnauthorized, ""), nil
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	refreshes := 0
+	refresh := func(context.Context) (string, error) {
+		refreshes++
+		return "Bearer fresh", nil
+	}
+	c := Decorate(next, RefreshAuthorization("Bearer stale", refresh))
+
+	for i := 0; i < 2; i++ {
+		if res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)); res.StatusCode != http.StatusOK {
+			t.Fatalf("got %d, want 200", res.StatusCode)
+		}
+	}
+	if want := []string{"Bearer stale", "Bearer fresh", "Bearer fresh"}; !reflect.DeepEqual(seen, want) || refreshes != 1 {
+		t.Fatalf("sent %q after %d refreshes, want %q after 1", seen, refreshes, want)
+	}
+
+	// A token that keeps being rejected is refreshed once per request only.
+	valid, seen, refreshes = "never", nil, 0
+	if res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)); res.StatusCode != http.StatusUnauthorized || len(seen) != 2 || refreshes != 1 {
+		t.Fatalf("got %d after %d attempts and %d refreshes, want 401 after 2 and 1", res.StatusCode, len(seen), refreshes)
+	}
+
+	// An unrewindable body isn't sent twice.
+	seen, refreshes = nil, 0
+	if res := do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable("data"))); res.StatusCode != http.StatusUnauthorized || len(seen) != 1 || refreshes != 0 {
+		t.Fatalf("got %d after %d attempts and %d refreshes, want 401 after 1 and none", res.StatusCode, len(seen), refreshes)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	var seen []string
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		seen = append(seen, r.Header.Get("X-Seq"))
+		if len(seen)%2 == 1 {
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	for _, c := range []Client{
+		Decorate(next, FaultTolerance(1, 0), Sequence("X-Seq")),
+		Decorate(next, Sequence("X-Seq"), FaultTolerance(1, 0)),
+	} {
+		seen = nil
+		for i := 0; i < 2; i++ {
+			do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+		}
+		if want := []string{"1", "1", "2", "2"}; !reflect.DeepEqual(seen, want) {
+			t.Errorf("sent sequence numbers %q, want %q", seen, want)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	var got http.Header
+	fromPath := func(r *http.Request) string {
+		if r.URL.Path == "/known" {
+			return `"v2"`
+		}
+		return ""
+	}
+	c := Decorate(headersOf(&got), IfMatch(fromPath))
+	for _, tc := range []struct {
+		path, ctxTag, want string
+	}{
+		{"/known", `"v1"`, `"v1"`},
+		{"/known", "", `"v2"`},
+		{"/other", "", ""},
+	} {
+		ctx := context.Background()
+		if tc.ctxTag != "" {
+			ctx = ContextWithIfMatch(ctx, tc.ctxTag)
+		}
+		do(t, c, newRequest(t, http.MethodPut, "http://example.com"+tc.path, nil).WithContext(ctx)).Body.Close()
+		if got := got.Get("If-Match"); got != tc.want {
+			t.Errorf("%s with %q in context: If-Match = %q, want %q", tc.path, tc.ctxTag, got, tc.want)
+		}
+	}
+
+	c = Decorate(headersOf(&got), IfMatch(nil))
+	do(t, c, newRequest(t, http.MethodPut, "http://example.com/", nil)).Body.Close()
+	if _, ok := got["If-Match"]; ok {
+		t.Fatal("set If-Match without an entity tag")
+	}
+}
+
+func TestVersionHeaders(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), VersionHeaders("billing", "1.4.2", "abc123"))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	for name, want := range map[string]string{
+		"X-Client-Service": "billing",
+		"X-Client-Version": "1.4.2",
+		"X-Client-Commit":  "abc123",
+	} {
+		if got := got.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "errors"
+
+// A HealthReporter is a Client that can tell whether it's currently able to
+// serve requests, returning a non-nil error when it isn't.
+type HealthReporter interface {
+	Health() error
+}
+
+// ChainHealth returns the joined errors of every unhealthy HealthReporter in
+// the chain of c, or nil if they're all healthy. The chain is followed like
+// Shutdown follows it.
+func ChainHealth(c Client) error {
+	var errs []error
+	walk(c, func(c Client) {
+		if h, ok := c.(HealthReporter); ok {
+			if err := h.Health(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Hedge returns a Decorator that sends a second copy of every idempotent
+// request still unanswered after delay, and returns whichever response comes
+// first, cancelling the other request. need is how long a request is
+// expected to take: for requests whose context has a deadline, the hedge is
+// sent sooner when waiting for delay would leave it less than need, and not
+// at all when there isn't need left already. A first attempt that fails
+// before the hedge is sent fails the request; otherwise the request fails
+// only once both attempts have.
+func Hedge(delay, need time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if !isIdempotent(r) {
+				return c.Do(r)
+			}
+			clock := clockFrom(r.Context())
+			d := delay
+			if deadline, ok := r.Context().Deadline(); ok {
+				left := deadline.Sub(clock.Now()) - need
+				if left <= 0 {
+					return c.Do(r)
+				}
+				if left < d {
+					d = left
+				}
+			}
+			if _, err := readBody(r); err != nil {
+				return nil, err
+			}
+
+			type result struct {
+				res *http.Response
+				err error
+				i   int
+			}
+			results := make(chan result, 2)
+			var cancels []context.CancelFunc
+			send := func() error {
+				ctx, cancel := context.WithCancel(r.Context())
+				req := r.Clone(ctx)
+				if r.GetBody != nil {
+					body, err := r.GetBody()
+					if err != nil {
+						cancel()
+						return err
+					}
+					req.Body = body
+				}
+				i := len(cancels)
+				cancels = append(cancels, cancel)
+				go func() {
+					res, err := c.Do(req)
+					results <- result{res, err, i}
+				}()
+				return nil
+			}
+			if err := send(); err != nil {
+				return nil, err
+			}
+
+			hedge := clock.After(d)
+			pending := 1
+			for {
+				select {
+				case <-hedge:
+					if err := send(); err == nil {
+						pending++
+					}
+				case res := <-results:
+					pending--
+					if res.err == nil {
+						res.res.Body = &cancelBody{ReadCloser: res.res.Body, cancel: cancels[res.i]}
+						for i, cancel := range cancels {
+							if i != res.i {
+								cancel()
+							}
+						}
+						go func(pending int) {
+							for ; pending > 0; pending-- {
+								if loser := <-results; loser.err == nil {
+									loser.res.Body.Close()
+								}
+							}
+						}(pending)
+						return res.res, nil
+					}
+					cancels[res.i]()
+					if pending == 0 {
+						return nil, res.err
+					}
+				}
+			}
+		})
+	}
+}
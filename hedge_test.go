@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hedged returns a Client whose first request hangs until its context is
+// done, and which answers the others with their number.
+func hedged(calls *atomic.Int32, cancelled chan<- struct{}) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		n := calls.Add(1)
+		if n == 1 {
+			<-r.Context().Done()
+			close(cancelled)
+			return nil, r.Context().Err()
+		}
+		return newResponse(r, http.StatusOK, "hedge"), nil
+	})
+}
+
+func TestHedge(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	cancelled := make(chan struct{})
+	c := Decorate(hedged(&calls, cancelled), Hedge(50*time.Millisecond, 0), WithClock(clock))
+
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "hedge" {
+		t.Fatalf("got %q, want the hedge's response", got)
+	}
+	<-cancelled
+	if got, want := clock.Waits(), []time.Duration{50 * time.Millisecond}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v before hedging, want %v", got, want)
+	}
+
+	var posts atomic.Int32
+	c = Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		posts.Add(1)
+		return newResponse(r, http.StatusOK, ""), nil
+	}), Hedge(0, 0), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", nil)).Body.Close()
+	if posts.Load() != 1 {
+		t.Fatalf("sent a POST %d times, want it never hedged", posts.Load())
+	}
+}
+
+func TestHedgeDeadline(t *testing.T) {
+	for _, tc := range []struct {
+		need  time.Duration
+		waits []time.Duration
+	}{
+		{10 * time.Minute, []time.Duration{50 * time.Minute}},
+		{2 * time.Hour, nil},
+	} {
+		clock := &fakeClock{now: time.Now()}
+		var calls atomic.Int32
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return newResponse(r, http.StatusOK, ""), nil
+		}), Hedge(2*time.Hour, tc.need), WithClock(clock))
+		ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Hour))
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)).Body.Close()
+		cancel()
+		if got := clock.Waits(); !reflect.DeepEqual(got, tc.waits) {
+			t.Errorf("need %v: waited %v before hedging, want %v", tc.need, got, tc.waits)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// ErrSchemaViolation is returned by the schema validating Decorators for
+// bodies that don't conform to their JSON Schema.
+var ErrSchemaViolation = errors.New("JSON schema violation")
+
+// ValidateJSONSchema returns a Decorator that validates the body of every
+// JSON response against the given JSON Schema. Responses that don't conform
+// are returned along with an error wrapping ErrSchemaViolation. Either way,
+// the response body is left readable from the start.
+//
+// Only a subset of JSON Schema is supported: the type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum and maximum keywords. Others are
+// ignored. An invalid schema fails every request.
+func ValidateJSONSchema(schema []byte) Decorator {
+	s, serr := compileSchema(schema)
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if serr != nil {
+				return nil, serr
+			}
+			res, err := c.Do(r)
+			if err != nil || !hasContent(res) || !isJSON(res.Header.Get("Content-Type")) {
+				return res, err
+			}
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			if err != nil {
+				return res, err
+			}
+			return res, s.validateJSON(body)
+		})
+	}
+}
+
+// ValidateRequestJSON returns a Decorator that validates the body of every
+// JSON request against the given JSON Schema, as ValidateJSONSchema does for
+// responses, and fails requests that don't conform without sending them.
+// Requests that conform are sent with their body restored through GetBody.
+func ValidateRequestJSON(schema []byte) Decorator {
+	s, serr := compileSchema(schema)
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if serr != nil {
+				return nil, serr
+			}
+			if r.Body == nil || r.Body == http.NoBody || !isJSON(r.Header.Get("Content-Type")) {
+				return c.Do(r)
+			}
+			body, err := readBody(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.validateJSON(body); err != nil {
+				return nil, err
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// jsonSchema is a compiled JSON Schema.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                *json.RawMessage       `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+
+	pattern    *regexp.Regexp
+	additional *jsonSchema
+	noExtra    bool
+	constant   interface{}
+}
+
+// schemaTypes is the value of the type keyword, either a name or a list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// compileSchema parses a JSON Schema document.
+func compileSchema(schema []byte) (*jsonSchema, error) {
+	var s jsonSchema
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &s, nil
+}
+
+// compile prepares s and its subschemas for validation.
+func (s *jsonSchema) compile() (err error) {
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	if s.Const != nil {
+		if err := json.Unmarshal(*s.Const, &s.constant); err != nil {
+			return err
+		}
+	}
+	switch extra := bytes.TrimSpace(s.AdditionalProperties); {
+	case len(extra) == 0, bytes.Equal(extra, []byte("true")):
+	case bytes.Equal(extra, []byte("false")):
+		s.noExtra = true
+	default:
+		s.additional = &jsonSchema{}
+		if err := json.Unmarshal(extra, s.additional); err != nil {
+			return err
+		}
+		if err := s.additional.compile(); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// validateJSON reports whether the JSON document data conforms to s.
+func (s *jsonSchema) validateJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%w: %v", ErrSchemaViolation, err)
+	}
+	return s.validate(v, "$")
+}
+
+// validate reports whether the decoded JSON value v, found at path, conforms to s.
+func (s *jsonSchema) validate(v interface{}, path string) error {
+	violation := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s: %s", ErrSchemaViolation, path, fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		return violation("expected %v, got %s", []string(s.Type), jsonType(v))
+	}
+	if s.Enum != nil {
+		found := false
+		for _, e := range s.Enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			return violation("%v is not one of %v", v, s.Enum)
+		}
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.constant, v) {
+		return violation("%v is not %v", v, s.constant)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return violation("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			switch {
+			case ok:
+			case s.noExtra:
+				return violation("unexpected property %q", name)
+			case s.additional != nil:
+				p = s.additional
+			default:
+				continue
+			}
+			if err := p.validate(v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return violation("%d items, fewer than %d", len(v), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return violation("%d items, more than %d", len(v), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return violation("length %d, shorter than %d", n, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return violation("length %d, longer than %d", n, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return violation("%q doesn't match %q", v, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return violation("%v is less than %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return violation("%v is greater than %v", v, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+// match reports whether the decoded JSON value v has one of the types t.
+func (t schemaTypes) match(v interface{}) bool {
+	actual := jsonType(v)
+	for _, name := range t {
+		if name == actual || name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type name of the decoded JSON value v.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
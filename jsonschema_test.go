@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+	}
+}`
+
+// jsonResponse returns a Client that answers every request with the given
+// JSON body.
+func jsonResponse(body string) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, body)
+		res.Header.Set("Content-Type", "application/json")
+		return res, nil
+	})
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	for body, valid := range map[string]bool{
+		`{"name": "ana", "age": 30}`:                                 true,
+		`{"name": "ana", "age": 30, "role": "admin", "tags": ["a"]}`: true,
+		`{"name": "ana"}`:                                            false,
+		`{"name": "Ana", "age": 30}`:                                 false,
+		`{"name": "ana", "age": 30.5}`:                               false,
+		`{"name": "ana", "age": 200}`:                                false,
+		`{"name": "ana", "age": 30, "role": "root"}`:                 false,
+		`{"name": "ana", "age": 30, "tags": ["a", "b", "c"]}`:        false,
+		`{"name": "ana", "age": 30, "tags": [1]}`:                    false,
+		`{"name": "ana", "age": 30, "extra": true}`:                  false,
+		`[]`: false,
+	} {
+		c := Decorate(jsonResponse(body), ValidateJSONSchema([]byte(userSchema)))
+		res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+		if got := !errors.Is(err, ErrSchemaViolation); got != valid {
+			t.Errorf("%s: Do() error = %v, want valid: %v", body, err, valid)
+		}
+		if got := bodyString(t, res); got != body {
+			t.Errorf("%s: body = %q, want it left readable", body, got)
+		}
+	}
+}
+
+func TestValidateJSONSchemaInvalidSchema(t *testing.T) {
+	c := Decorate(jsonResponse(`{}`), ValidateJSONSchema([]byte(`{"pattern": "("}`)))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err == nil {
+		t.Fatal("Do() with an invalid schema succeeded, want an error")
+	}
+}
+
+func TestValidateRequestJSON(t *testing.T) {
+	var sent []string
+	c := Decorate(bodies(0, &sent), ValidateRequestJSON([]byte(userSchema)))
+	post := func(contentType, body string) error {
+		r := newRequest(t, http.MethodPost, "http://example.com/users", unseekable(body))
+		r.Header.Set("Content-Type", contentType)
+		res, err := c.Do(r)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	if err := post("application/json", `{"name": "ana", "age": 30}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := post("application/json", `{"name": "ana"}`); !errors.Is(err, ErrSchemaViolation) {
+		t.Fatalf("invalid body: Do() error = %v, want %v", err, ErrSchemaViolation)
+	}
+	if err := post("text/plain", `{"name": "ana"}`); err != nil {
+		t.Fatalf("non-JSON body: %v", err)
+	}
+	if want := []string{`{"name": "ana", "age": 30}`, `{"name": "ana"}`}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent bodies %q, want %q", sent, want)
+	}
+}
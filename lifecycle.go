@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// A Shutdowner is a Client that runs background work which must be stopped
+// before the Client is discarded.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// A Wrapper is a Client that decorates another Client, which Unwrap returns.
+type Wrapper interface {
+	Unwrap() Client
+}
+
+// Shutdown shuts down every Shutdowner in the chain of c, from outermost to
+// innermost, and waits for their background work to drain or for ctx to be
+// done. The chain is followed through the layers built by Decorate and Named
+// and through Wrappers; the walk stops at the first Client that is neither.
+func Shutdown(ctx context.Context, c Client) error {
+	var errs []error
+	walk(c, func(c Client) {
+		if s, ok := c.(Shutdowner); ok {
+			if err := s.Shutdown(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// walk calls fn for every Client in the chain of c, from outermost to
+// innermost. For the layers built by Decorate and Named, it visits both the
+// layer and its Decorator's Client before moving on to the Client decorated.
+func walk(c Client, fn func(Client)) {
+	for c != nil {
+		fn(c)
+		if l, ok := c.(*layer); ok {
+			if _, ok := l.decorated.(Wrapper); ok {
+				// The decorated Client leads to l.next on its own.
+				c = l.decorated
+				continue
+			}
+			fn(l.decorated)
+			c = l.next
+			continue
+		}
+		w, ok := c.(Wrapper)
+		if !ok {
+			return
+		}
+		c = w.Unwrap()
+	}
+}
+
+// wait waits for wg to be done or for ctx to be done, whichever comes first.
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
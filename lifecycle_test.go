@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stopper is a Shutdowner recording the order in which it is shut down.
+type stopper struct {
+	name  string
+	next  Client
+	order *[]string
+	err   error
+}
+
+func (s *stopper) Do(r *http.Request) (*http.Response, error) { return s.next.Do(r) }
+func (s *stopper) Unwrap() Client                             { return s.next }
+
+func (s *stopper) Shutdown(context.Context) error {
+	*s.order = append(*s.order, s.name)
+	return s.err
+}
+
+func TestShutdownWalksTheChain(t *testing.T) {
+	var order []string
+	errInner := errors.New("inner failed")
+	stop := func(name string, err error) Decorator {
+		return func(c Client) Client { return &stopper{name: name, next: c, order: &order, err: err} }
+	}
+	identity := func(c Client) Client { return c }
+	c := Decorate(respond(http.StatusOK, ""), stop("inner", errInner), Named("named", identity), stop("outer", nil))
+
+	if err := Shutdown(context.Background(), c); !errors.Is(err, errInner) {
+		t.Fatalf("Shutdown() = %v, want %v", err, errInner)
+	}
+	if want := []string{"outer", "inner"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("shut down %v, want %v", order, want)
+	}
+}
+
+func TestShutdownHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		<-release
+		return newResponse(r, http.StatusOK, ""), nil
+	}), Async(nil))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Shutdown(ctx, c); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// budgetChunk is the number of bytes a body is buffered by at a time within
+// a MemoryBudget.
+const budgetChunk = 32 << 10
+
+// A MemoryBudget caps how many bytes of response bodies the buffering
+// Decorators wrapped by its Decorator hold in memory at once, all together.
+// These are the Decorators of a Cache, which hold the bodies of the responses
+// they store, CoalesceByIdempotencyKey, which holds a body until every copy
+// of it is closed, and those of a HAR, which hold the bodies they record. A
+// body that doesn't fit in what's left of the budget isn't buffered: its
+// response is streamed to its caller as it is, uncached, unshared or
+// unrecorded.
+type MemoryBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// NewMemoryBudget returns a MemoryBudget of maxBytes.
+func NewMemoryBudget(maxBytes int64) *MemoryBudget {
+	return &MemoryBudget{max: maxBytes}
+}
+
+// Decorator returns a Decorator that subjects the buffering Decorators it
+// wraps to b.
+func (b *MemoryBudget) Decorator() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return c.Do(r.WithContext(context.WithValue(r.Context(), memoryBudgetKey, b)))
+		})
+	}
+}
+
+// InUse returns the number of bytes currently held within b.
+func (b *MemoryBudget) InUse() int64 {
+	return b.used.Load()
+}
+
+// reserve takes n bytes from b, unless fewer are left.
+func (b *MemoryBudget) reserve(n int64) bool {
+	if b.used.Add(n) > b.max {
+		b.used.Add(-n)
+		return false
+	}
+	return true
+}
+
+// release gives back n bytes taken by reserve.
+func (b *MemoryBudget) release(n int64) {
+	b.used.Add(-n)
+}
+
+// bufferResponse reads and closes the body of res to make it shareable, as
+// shareResponse does, within the MemoryBudget of ctx, if any. The returned
+// function gives the memory back to the budget once the body is no longer
+// held. If the body doesn't fit, bufferResponse returns a nil sharedResponse
+// and leaves res with a body that still reads in full.
+func bufferResponse(ctx context.Context, res *http.Response) (*sharedResponse, func(), error) {
+	budget, ok := ctx.Value(memoryBudgetKey).(*MemoryBudget)
+	if !ok {
+		shared, err := shareResponse(res)
+		return shared, func() {}, err
+	}
+
+	var (
+		buf      bytes.Buffer
+		reserved int64
+	)
+	for {
+		if !budget.reserve(budgetChunk) {
+			res.Body = &budgetBody{
+				Reader:   io.MultiReader(bytes.NewReader(buf.Bytes()), res.Body),
+				Closer:   res.Body,
+				budget:   budget,
+				reserved: reserved,
+			}
+			return nil, func() {}, nil
+		}
+		reserved += budgetChunk
+		n, err := io.CopyN(&buf, res.Body, budgetChunk)
+		if err == io.EOF || err == nil && n < budgetChunk {
+			break
+		}
+		if err != nil {
+			res.Body.Close()
+			budget.release(reserved)
+			return nil, func() {}, err
+		}
+	}
+	res.Body.Close()
+	size := int64(buf.Len())
+	budget.release(reserved - size)
+	var once atomic.Bool
+	release := func() {
+		if once.CompareAndSwap(false, true) {
+			budget.release(size)
+		}
+	}
+	return &sharedResponse{res: res, body: buf.Bytes()}, release, nil
+}
+
+// budgetBody is a response body partly read into memory, which keeps its
+// share of a MemoryBudget until closed.
+type budgetBody struct {
+	io.Reader
+	io.Closer
+	budget   *MemoryBudget
+	reserved int64
+	released atomic.Bool
+}
+
+func (b *budgetBody) Close() error {
+	if b.released.CompareAndSwap(false, true) {
+		b.budget.release(b.reserved)
+	}
+	return b.Closer.Close()
+}
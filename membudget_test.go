@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetCache(t *testing.T) {
+	budget := NewMemoryBudget(40 << 10)
+	body := strings.Repeat("x", 30<<10)
+	c := Decorate(respond(http.StatusOK, body), NewCache(time.Minute, 0).Decorator(), budget.Decorator())
+
+	if got, status := get(t, c, "http://example.com/a"); got != body || status != "MISS" {
+		t.Fatalf("first /a = %d bytes, %s, want the body, MISS", len(got), status)
+	}
+	if budget.InUse() != int64(len(body)) {
+		t.Fatalf("InUse() = %d with /a cached, want %d", budget.InUse(), len(body))
+	}
+	// The budget is saturated: /b streams through instead of being cached.
+	for i := 0; i < 2; i++ {
+		if got, status := get(t, c, "http://example.com/b"); got != body || status != "MISS" {
+			t.Fatalf("/b %d = %d bytes, %s, want the body streamed, MISS", i, len(got), status)
+		}
+	}
+	if got, status := get(t, c, "http://example.com/a"); got != body || status != "HIT" {
+		t.Fatalf("second /a = %d bytes, %s, want the body, HIT", len(got), status)
+	}
+	if budget.InUse() != int64(len(body)) {
+		t.Fatalf("InUse() = %d, want only /a held", budget.InUse())
+	}
+}
+
+func TestMemoryBudgetCoalesce(t *testing.T) {
+	budget := NewMemoryBudget(1 << 20)
+	var calls atomic.Int32
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	c := Decorate(gate("shared", &calls, entered, release), CoalesceByIdempotencyKey(), budget.Decorator())
+
+	responses := make(chan *http.Response, 2)
+	send := func() {
+		r := newRequest(t, http.MethodPost, "http://example.com/", nil)
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		res, err := c.Do(r)
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- res
+	}
+	go send()
+	<-entered
+	go send()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	first, second := <-responses, <-responses
+	if first == nil || second == nil {
+		t.FailNow()
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("made %d upstream calls, want 1", calls.Load())
+	}
+
+	if budget.InUse() != int64(len("shared")) {
+		t.Fatalf("InUse() = %d before the copies are closed, want %d", budget.InUse(), len("shared"))
+	}
+	if got := bodyString(t, first); got != "shared" {
+		t.Fatalf("first body = %q", got)
+	}
+	first.Body.Close()
+	if budget.InUse() != int64(len("shared")) {
+		t.Fatalf("InUse() = %d with a copy still open, want %d", budget.InUse(), len("shared"))
+	}
+	if got := bodyString(t, second); got != "shared" {
+		t.Fatalf("second body = %q", got)
+	}
+	if budget.InUse() != 0 {
+		t.Fatalf("InUse() = %d with every copy closed, want 0", budget.InUse())
+	}
+}
+
+func TestMemoryBudgetHAR(t *testing.T) {
+	budget := NewMemoryBudget(150)
+	h := NewHAR(100, 1)
+	c := Decorate(respond(http.StatusOK, "hello"), h.Decorator(), budget.Decorator())
+
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("ping"))).Body.Close()
+	if budget.InUse() != int64(len("ping")+len("hello")) {
+		t.Fatalf("InUse() = %d, want the recorded bodies held", budget.InUse())
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if budget.InUse() != int64(len("hello")) {
+		t.Fatalf("InUse() = %d, want only the latest entry held", budget.InUse())
+	}
+	h.Reset()
+	if budget.InUse() != 0 {
+		t.Fatalf("InUse() = %d after Reset, want 0", budget.InUse())
+	}
+
+	// Once the budget can't hold another body, bodies stream unrecorded.
+	budget = NewMemoryBudget(50)
+	c = Decorate(respond(http.StatusOK, "hello"), h.Decorator(), budget.Decorator())
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodPost, "http://example.com/", strings.NewReader("ping")))); got != "hello" {
+		t.Fatalf("body = %q, want it in full", got)
+	}
+	e := entriesOf(t, h)[0]
+	if e.Request.PostData != nil || e.Response.Content.Text != "" || budget.InUse() != 0 {
+		t.Fatalf("recorded %+v and %q holding %d bytes, want no bodies", e.Request.PostData, e.Response.Content.Text, budget.InUse())
+	}
+}
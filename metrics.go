@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Counter is a metric that accumulates a monotonically increasing count.
+type Counter interface {
+	Add(delta uint64)
+}
+
+// A Histogram is a metric that observes values and summarizes their distribution.
+type Histogram interface {
+	Observe(value int64)
+}
+
+// A Gauge is a metric that tracks a value going up and down, e.g. the number
+// of requests in flight.
+type Gauge interface {
+	Add(delta int64)
+}
+
+// AtomicGauge is the default Gauge implementation returned by NewGauge.
+type AtomicGauge struct {
+	name  string
+	value atomic.Int64
+}
+
+// NewGauge returns a Gauge with the given name.
+func NewGauge(name string) *AtomicGauge {
+	return &AtomicGauge{name: name}
+}
+
+// Add changes the gauge by delta.
+func (g *AtomicGauge) Add(delta int64) {
+	g.value.Add(delta)
+}
+
+// Value returns the current value.
+func (g *AtomicGauge) Value() int64 {
+	return g.value.Load()
+}
+
+// An ExemplarHistogram is a Histogram that can attach an exemplar, i.e. labels
+// identifying the source of an observation such as the trace it belongs to,
+// to the observations it records.
+type ExemplarHistogram interface {
+	Histogram
+	ObserveWithExemplar(value int64, exemplar map[string]string)
+}
+
+// AtomicCounter is the default Counter implementation returned by NewCounter.
+type AtomicCounter struct {
+	name  string
+	value uint64
+}
+
+// NewCounter returns a Counter with the given name.
+func NewCounter(name string) *AtomicCounter {
+	return &AtomicCounter{name: name}
+}
+
+// Add increments the counter by delta.
+func (c *AtomicCounter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current count.
+func (c *AtomicCounter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// A CounterVec is a family of Counters partitioned by label values.
+type CounterVec interface {
+	With(labels map[string]string) Counter
+}
+
+// AtomicCounterVec is the default CounterVec implementation returned by
+// NewCounterVec.
+type AtomicCounterVec struct {
+	name string
+
+	mu       sync.Mutex
+	counters map[string]*AtomicCounter
+	labels   map[string]map[string]string
+}
+
+// NewCounterVec returns a CounterVec with the given name.
+func NewCounterVec(name string) *AtomicCounterVec {
+	return &AtomicCounterVec{name: name, counters: map[string]*AtomicCounter{}, labels: map[string]map[string]string{}}
+}
+
+// With returns the Counter for the given label combination, creating it
+// on first use.
+func (v *AtomicCounterVec) With(labels map[string]string) Counter {
+	return v.counter(labels)
+}
+
+// Value returns the current count for the given label combination.
+func (v *AtomicCounterVec) Value(labels map[string]string) uint64 {
+	return v.counter(labels).Value()
+}
+
+func (v *AtomicCounterVec) counter(labels map[string]string) *AtomicCounter {
+	key := labelKey(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[key]
+	if !ok {
+		c = NewCounter(v.name)
+		v.counters[key] = c
+		v.labels[key] = copyLabels(labels)
+	}
+	return c
+}
+
+// copyLabels returns a copy of labels, which a caller may reuse.
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for name, value := range labels {
+		copied[name] = value
+	}
+	return copied
+}
+
+// labelKey serializes labels into a string that is independent of map order.
+func labelKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(strconv.Quote(name))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// A Labeler derives metric labels from an http.Request.
+type Labeler func(*http.Request) map[string]string
+
+// MethodHost is a Labeler that labels requests by their method and host.
+func MethodHost(r *http.Request) map[string]string {
+	return map[string]string{"method": r.Method, "host": r.URL.Host}
+}
+
+// HeaderLabel returns a Labeler that labels requests with the value of the
+// given header under the given label, e.g. to break traffic down by a tenant
+// ID header. Requests without the header get an empty label value.
+func HeaderLabel(header, label string) Labeler {
+	return func(r *http.Request) map[string]string {
+		return map[string]string{label: r.Header.Get(header)}
+	}
+}
+
+// Labels returns a Labeler that merges the labels of all the given Labelers,
+// later ones winning over earlier ones for the same label.
+func Labels(labelers ...Labeler) Labeler {
+	return func(r *http.Request) map[string]string {
+		merged := map[string]string{}
+		for _, labels := range labelers {
+			for name, value := range labels(r) {
+				merged[name] = value
+			}
+		}
+		return merged
+	}
+}
+
+// LabeledInstrumentation returns a Decorator that counts a Client's requests
+// in the given CounterVec under the labels derived by the given Labeler.
+func LabeledInstrumentation(requests CounterVec, labels Labeler) Decorator {
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			defer requests.With(labels(r)).Add(1)
+			return c.Do(r)
+		}, requests)
+	}
+}
+
+// CountByStatus returns a Decorator that counts every response in the
+// Counter mapped to its status code. Errors and responses with an unmapped
+// status code are counted in the Counter mapped to 0, if any.
+func CountByStatus(counters map[int]Counter) Decorator {
+	codes := make([]int, 0, len(counters))
+	for code := range counters {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	metrics := make([]interface{}, len(codes))
+	for i, code := range codes {
+		metrics[i] = counters[code]
+	}
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			counter, ok := counters[0]
+			if err == nil {
+				if mapped, found := counters[res.StatusCode]; found {
+					counter, ok = mapped, true
+				}
+			}
+			if ok {
+				counter.Add(1)
+			}
+			return res, err
+		}, metrics...)
+	}
+}
+
+// SemconvInstrumentation returns a Decorator that records, for every request,
+// a count in requests and its duration in nanoseconds in duration, both with
+// the OpenTelemetry semantic convention attributes http.method and
+// http.status_code, the latter being 0 for requests that fail with an error,
+// and tracks the requests in flight in inflight. Backing the metrics with
+// instruments of an OpenTelemetry meter exports them as the conventions
+// expect, without this package depending on OpenTelemetry.
+func SemconvInstrumentation(requests CounterVec, duration HistogramVec, inflight Gauge) Decorator {
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			inflight.Add(1)
+			start := time.Now()
+			res, err := c.Do(r)
+			inflight.Add(-1)
+			status := 0
+			if err == nil {
+				status = res.StatusCode
+			}
+			labels := map[string]string{"http.method": r.Method, "http.status_code": strconv.Itoa(status)}
+			duration.With(labels).Observe(time.Since(start).Nanoseconds())
+			requests.With(labels).Add(1)
+			return res, err
+		}, requests, duration, inflight)
+	}
+}
+
+// InstrumentationWithExemplars returns a Decorator like Instrumentation that,
+// when latency is an ExemplarHistogram and the request carries a W3C
+// traceparent header, attaches the trace ID to each latency observation as a
+// "trace_id" exemplar, linking the metric to the trace.
+func InstrumentationWithExemplars(requests Counter, latency Histogram) Decorator {
+	exemplars, _ := latency.(ExemplarHistogram)
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			defer func(start time.Time) {
+				elapsed := time.Since(start).Nanoseconds()
+				if id, ok := TraceID(r); ok && exemplars != nil {
+					exemplars.ObserveWithExemplar(elapsed, map[string]string{"trace_id": id})
+				} else {
+					latency.Observe(elapsed)
+				}
+				requests.Add(1)
+			}(time.Now())
+			return c.Do(r)
+		}, requests, latency)
+	}
+}
+
+// TraceID returns the trace ID of the W3C traceparent header of r, if it has
+// a valid one.
+func TraceID(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// histogramReservoir is the number of observations a QuantileHistogram keeps
+// at most.
+const histogramReservoir = 4096
+
+// QuantileHistogram is the default Histogram implementation returned by
+// NewHistogram. It keeps a uniform sample of up to histogramReservoir
+// observations, from which the configured quantiles are computed: exactly
+// until the sample is full, and approximately after that, in bounded memory.
+// The count and the sum of the observations are always exact.
+type QuantileHistogram struct {
+	name      string
+	min, max  int64
+	sigfigs   int
+	quantiles []int
+
+	mu       sync.Mutex
+	values   []int64
+	count    int
+	sum      int64
+	exemplar *exemplar
+}
+
+// exemplar is the last observation recorded with exemplar labels.
+type exemplar struct {
+	value  int64
+	labels map[string]string
+}
+
+// NewHistogram returns a Histogram with the given name that clamps observations
+// to [min, max], keeps sigfigs significant digits of each one and reports the
+// given percentile quantiles.
+func NewHistogram(name string, min, max int64, sigfigs int, quantiles ...int) *QuantileHistogram {
+	return &QuantileHistogram{
+		name:      name,
+		min:       min,
+		max:       max,
+		sigfigs:   sigfigs,
+		quantiles: quantiles,
+	}
+}
+
+// Observe records the given value.
+func (h *QuantileHistogram) Observe(value int64) {
+	value = h.normalize(value)
+	h.mu.Lock()
+	h.record(value)
+	h.mu.Unlock()
+}
+
+// ObserveWithExemplar records the given value, like Observe, and keeps the
+// value recorded along with a copy of the given exemplar labels as the
+// histogram's latest exemplar.
+func (h *QuantileHistogram) ObserveWithExemplar(value int64, labels map[string]string) {
+	value = h.normalize(value)
+	labels = copyLabels(labels)
+	h.mu.Lock()
+	h.record(value)
+	h.exemplar = &exemplar{value: value, labels: labels}
+	h.mu.Unlock()
+}
+
+// normalize clamps v to the configured bounds and truncates it to the
+// configured significant figures, as it is recorded.
+func (h *QuantileHistogram) normalize(v int64) int64 {
+	if v < h.min {
+		v = h.min
+	} else if v > h.max {
+		v = h.max
+	}
+	return h.truncate(v)
+}
+
+// record adds the normalized value v to the observations. It must be called
+// with h.mu held.
+func (h *QuantileHistogram) record(v int64) {
+	h.count++
+	h.sum += v
+	if len(h.values) < histogramReservoir {
+		h.values = append(h.values, v)
+	} else if i := rand.Intn(h.count); i < histogramReservoir {
+		h.values[i] = v
+	}
+}
+
+// Exemplar returns the latest value recorded with ObserveWithExemplar and its
+// exemplar labels, if any.
+func (h *QuantileHistogram) Exemplar() (int64, map[string]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.exemplar == nil {
+		return 0, nil, false
+	}
+	return h.exemplar.value, copyLabels(h.exemplar.labels), true
+}
+
+// Snapshot returns the value of each configured quantile, in the order they
+// were given to NewHistogram. Quantiles of an empty histogram are zero.
+func (h *QuantileHistogram) Snapshot() []float64 {
+	snapshot, _, _ := h.summary()
+	return snapshot
+}
+
+// summary returns the quantiles of h, as Snapshot does, along with the number
+// and the sum of its observations.
+func (h *QuantileHistogram) summary() (snapshot []float64, count int, sum int64) {
+	h.mu.Lock()
+	values := make([]int64, len(h.values))
+	copy(values, h.values)
+	count, sum = h.count, h.sum
+	h.mu.Unlock()
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	snapshot = make([]float64, len(h.quantiles))
+	if len(values) == 0 {
+		return snapshot, 0, 0
+	}
+	for i, q := range h.quantiles {
+		rank := int(math.Ceil(float64(q)/100*float64(len(values)))) - 1
+		if rank < 0 {
+			rank = 0
+		} else if rank >= len(values) {
+			rank = len(values) - 1
+		}
+		snapshot[i] = float64(values[rank])
+	}
+	return snapshot, count, sum
+}
+
+// Reset discards every observation recorded so far.
+func (h *QuantileHistogram) Reset() {
+	h.mu.Lock()
+	h.values = nil
+	h.count, h.sum = 0, 0
+	h.exemplar = nil
+	h.mu.Unlock()
+}
+
+// truncate drops the digits of v beyond the configured significant figures.
+func (h *QuantileHistogram) truncate(v int64) int64 {
+	if h.sigfigs <= 0 || v == 0 {
+		return v
+	}
+	limit := int64(math.Pow10(h.sigfigs))
+	unit := int64(1)
+	for v/unit >= limit || -v/unit >= limit {
+		unit *= 10
+	}
+	return v / unit * unit
+}
+
+// BucketedHistogram is a Histogram that counts observations in buckets with
+// fixed upper bounds, rather than keeping them all like QuantileHistogram.
+type BucketedHistogram struct {
+	name   string
+	bounds []float64
+	counts []uint64 // one per bound, plus one for values above all bounds
+	sum    atomic.Int64
+}
+
+// NewBucketedHistogram returns a Histogram with the given name that counts
+// every observation in the first bucket whose upper bound, among the given
+// ones, is at least the observed value. Values above every bound are counted
+// in an extra overflow bucket.
+func NewBucketedHistogram(name string, bounds ...float64) *BucketedHistogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	return &BucketedHistogram{name: name, bounds: sorted, counts: make([]uint64, len(sorted)+1)}
+}
+
+// Observe records the given value.
+func (h *BucketedHistogram) Observe(value int64) {
+	i := sort.SearchFloat64s(h.bounds, float64(value))
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.Add(value)
+}
+
+// Bounds returns the upper bounds of the buckets, in increasing order.
+func (h *BucketedHistogram) Bounds() []float64 {
+	return append([]float64(nil), h.bounds...)
+}
+
+// Counts returns the number of observations in each bucket, in the order of
+// Bounds, followed by the number of those in the overflow bucket.
+func (h *BucketedHistogram) Counts() []uint64 {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return counts
+}
+
+// Sum returns the sum of every observed value.
+func (h *BucketedHistogram) Sum() int64 {
+	return h.sum.Load()
+}
+
+// A HistogramVec is a family of Histograms partitioned by label values.
+type HistogramVec interface {
+	With(labels map[string]string) Histogram
+}
+
+// QuantileHistogramVec is the default HistogramVec implementation returned by
+// NewHistogramVec.
+type QuantileHistogramVec struct {
+	name      string
+	min, max  int64
+	sigfigs   int
+	quantiles []int
+
+	mu         sync.Mutex
+	histograms map[string]*QuantileHistogram
+	labels     map[string]map[string]string
+}
+
+// NewHistogramVec returns a HistogramVec whose Histograms are configured like
+// those returned by NewHistogram.
+func NewHistogramVec(name string, min, max int64, sigfigs int, quantiles ...int) *QuantileHistogramVec {
+	return &QuantileHistogramVec{
+		name:       name,
+		min:        min,
+		max:        max,
+		sigfigs:    sigfigs,
+		quantiles:  quantiles,
+		histograms: map[string]*QuantileHistogram{},
+		labels:     map[string]map[string]string{},
+	}
+}
+
+// With returns the Histogram for the given label combination, creating it
+// on first use.
+func (v *QuantileHistogramVec) With(labels map[string]string) Histogram {
+	return v.histogram(labels)
+}
+
+// Snapshot returns the quantiles of the Histogram for the given label
+// combination.
+func (v *QuantileHistogramVec) Snapshot(labels map[string]string) []float64 {
+	return v.histogram(labels).Snapshot()
+}
+
+func (v *QuantileHistogramVec) histogram(labels map[string]string) *QuantileHistogram {
+	key := labelKey(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.histograms[key]
+	if !ok {
+		h = NewHistogram(v.name, v.min, v.max, v.sigfigs, v.quantiles...)
+		v.histograms[key] = h
+		v.labels[key] = copyLabels(labels)
+	}
+	return h
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestQuantileHistogramSnapshot(t *testing.T) {
+	h := NewHistogram("latency", 0, 1000, 0, 50, 90, 100)
+	if got := h.Snapshot(); !reflect.DeepEqual(got, []float64{0, 0, 0}) {
+		t.Fatalf("empty Snapshot() = %v, want zeros", got)
+	}
+	for v := int64(1); v <= 100; v++ {
+		h.Observe(v)
+	}
+	h.Observe(5000) // clamped to max
+	if got, want := h.Snapshot(), []float64{51, 91, 1000}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Snapshot() = %v, want %v", got, want)
+	}
+
+	h.Reset()
+	if _, count, sum := h.summary(); count != 0 || sum != 0 {
+		t.Fatalf("after Reset, count = %d and sum = %d, want 0", count, sum)
+	}
+}
+
+func TestQuantileHistogramTruncatesToSigfigs(t *testing.T) {
+	h := NewHistogram("latency", 0, 1e9, 2, 100)
+	h.Observe(123456)
+	if got := h.Snapshot()[0]; got != 120000 {
+		t.Fatalf("Snapshot()[0] = %v, want 120000", got)
+	}
+}
+
+func TestQuantileHistogramBoundsMemory(t *testing.T) {
+	const n = 100000
+	h := NewHistogram("latency", 0, n, 0, 50)
+	for v := int64(1); v <= n; v++ {
+		h.Observe(v)
+	}
+	if len(h.values) > histogramReservoir {
+		t.Fatalf("kept %d observations, want at most %d", len(h.values), histogramReservoir)
+	}
+	snapshot, count, sum := h.summary()
+	if count != n || sum != n*(n+1)/2 {
+		t.Fatalf("count = %d and sum = %d, want exact %d and %d", count, sum, n, n*(n+1)/2)
+	}
+	if p50 := snapshot[0]; math.Abs(p50-n/2) > n/20 {
+		t.Fatalf("p50 = %v, want about %v", p50, n/2)
+	}
+}
+
+func TestLabeledInstrumentation(t *testing.T) {
+	requests := NewCounterVec("requests")
+	c := Decorate(respond(http.StatusOK, ""), LabeledInstrumentation(requests, MethodHost))
+	for _, url := range []string{"http://a.example/x", "http://a.example/y", "http://b.example/"} {
+		do(t, c, newRequest(t, http.MethodGet, url, nil)).Body.Close()
+	}
+	do(t, c, newRequest(t, http.MethodPost, "http://a.example/", nil)).Body.Close()
+
+	for _, tc := range []struct {
+		labels map[string]string
+		want   uint64
+	}{
+		{map[string]string{"method": "GET", "host": "a.example"}, 2},
+		{map[string]string{"host": "b.example", "method": "GET"}, 1},
+		{map[string]string{"method": "POST", "host": "a.example"}, 1},
+		{map[string]string{"method": "PUT", "host": "a.example"}, 0},
+	} {
+		if got := requests.Value(tc.labels); got != tc.want {
+			t.Errorf("Value(%v) = %d, want %d", tc.labels, got, tc.want)
+		}
+	}
+}
+
+func TestCountByStatus(t *testing.T) {
+	ok, notFound, other := NewCounter("ok"), NewCounter("not_found"), NewCounter("other")
+	status := http.StatusOK
+	var fail bool
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if fail {
+			return nil, errFlaky
+		}
+		return newResponse(r, status, ""), nil
+	}), CountByStatus(map[int]Counter{http.StatusOK: ok, http.StatusNotFound: notFound, 0: other}))
+
+	for _, status = range []int{http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusTeapot} {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	}
+	fail = true
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != errFlaky {
+		t.Fatalf("Do() error = %v, want %v", err, errFlaky)
+	}
+	if ok.Value() != 2 || notFound.Value() != 1 || other.Value() != 2 {
+		t.Fatalf("counted %d OK, %d Not Found and %d other, want 2, 1 and 2", ok.Value(), notFound.Value(), other.Value())
+	}
+}
+
+func TestInstrumentationWithExemplars(t *testing.T) {
+	requests, latency := NewCounter("requests"), NewHistogram("latency", 0, 1e12, 0, 50)
+	c := Decorate(respond(http.StatusOK, ""), InstrumentationWithExemplars(requests, latency))
+
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if _, _, ok := latency.Exemplar(); ok {
+		t.Fatal("got an exemplar for a request without traceparent")
+	}
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	do(t, c, r).Body.Close()
+	if _, labels, ok := latency.Exemplar(); !ok || labels["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("exemplar labels = %v, want the trace ID", labels)
+	}
+	if _, count, _ := latency.summary(); requests.Value() != 2 || count != 2 {
+		t.Fatalf("counted %d requests and %d observations, want 2", requests.Value(), count)
+	}
+}
+
+func TestObserveWithExemplar(t *testing.T) {
+	h := NewHistogram("latency", 0, 1000, 2, 50)
+	labels := map[string]string{"trace_id": "a"}
+	h.ObserveWithExemplar(1234, labels)
+	labels["trace_id"] = "b"
+	if value, got, ok := h.Exemplar(); !ok || value != 1000 || got["trace_id"] != "a" {
+		t.Fatalf("Exemplar() = %d, %v, %v, want the clamped value with the original labels", value, got, ok)
+	}
+	h.ObserveWithExemplar(567, labels)
+	if value, _, _ := h.Exemplar(); value != 560 {
+		t.Fatalf("Exemplar() value = %d, want the truncated 560", value)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	for header, want := range map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01":                 "",
+		"": "",
+	} {
+		r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("traceparent", header)
+		if got, ok := TraceID(r); got != want || ok != (want != "") {
+			t.Errorf("TraceID(%q) = %q, %v, want %q", header, got, ok, want)
+		}
+	}
+}
+
+func TestBucketedHistogram(t *testing.T) {
+	h := NewBucketedHistogram("size", 100, 10, 1000)
+	for _, v := range []int64{1, 10, 11, 100, 500, 1000, 1001, 5000} {
+		h.Observe(v)
+	}
+	if got, want := h.Bounds(), []float64{10, 100, 1000}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Bounds() = %v, want %v", got, want)
+	}
+	if got, want := h.Counts(), []uint64{2, 2, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Counts() = %v, want %v", got, want)
+	}
+}
+
+func TestSemconvInstrumentation(t *testing.T) {
+	requests := NewCounterVec("http.client.requests")
+	duration := NewHistogramVec("http.client.duration", 0, 1e12, 0, 100)
+	inflight := NewGauge("http.client.active_requests")
+	var during int64
+	fail := false
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		during = inflight.Value()
+		if fail {
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusNotFound, ""), nil
+	}), SemconvInstrumentation(requests, duration, inflight))
+
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	fail = true
+	c.Do(newRequest(t, http.MethodPost, "http://example.com/", nil))
+
+	if during != 1 || inflight.Value() != 0 {
+		t.Fatalf("in flight = %d during and %d after the requests, want 1 and 0", during, inflight.Value())
+	}
+	for _, labels := range []map[string]string{
+		{"http.method": "GET", "http.status_code": "404"},
+		{"http.method": "POST", "http.status_code": "0"},
+	} {
+		if got := requests.Value(labels); got != 1 {
+			t.Errorf("requests %v = %d, want 1", labels, got)
+		}
+		if _, count, _ := duration.histogram(labels).summary(); count != 1 {
+			t.Errorf("durations %v = %d, want 1", labels, count)
+		}
+	}
+}
+
+func TestHeaderLabels(t *testing.T) {
+	labeler := Labels(MethodHost, HeaderLabel("X-Tenant", "tenant"), HeaderLabel("X-Region", "host"))
+	r := newRequest(t, http.MethodGet, "http://a.example/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	r.Header.Set("X-Region", "eu")
+	if got, want := labeler(r), map[string]string{"method": "GET", "host": "eu", "tenant": "acme"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("labels = %v, want %v", got, want)
+	}
+	if got := HeaderLabel("X-Tenant", "tenant")(newRequest(t, http.MethodGet, "http://a.example/", nil)); got["tenant"] != "" || len(got) != 1 {
+		t.Fatalf("without the header, labels = %v, want an empty tenant", got)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// NewMultipartRequest returns a request with the given method and URL whose
+// body is a multipart/form-data form of the given fields, set as
+// MultipartUpload sets it.
+func NewMultipartRequest(ctx context.Context, method, url string, fields map[string]io.Reader) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMultipartBody(r, fields); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// MultipartUpload returns a Decorator that replaces the body of every request
+// for which fields returns fields with a multipart/form-data form of them, in
+// order of name, and sets its Content-Type. Fields read from an *os.File are
+// sent as files, under its base name. The form is streamed as it is sent,
+// without being buffered. Its length is set when the sizes of all the fields
+// are known, i.e. they are files or have a Len method like *bytes.Reader, and
+// the request gets a GetBody when they are all io.Seekers.
+func MultipartUpload(fields func(*http.Request) map[string]io.Reader) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if f := fields(r); f != nil {
+				if err := setMultipartBody(r, f); err != nil {
+					return nil, err
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// setMultipartBody sets the body of r to a multipart form of fields.
+func setMultipartBody(r *http.Request, fields map[string]io.Reader) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	// The length of the form is that of its framing, written without the
+	// contents of the fields, plus theirs.
+	framing := &countingWriter{}
+	mw := multipart.NewWriter(framing)
+	mw.SetBoundary(boundary)
+	length := int64(0)
+	offsets := make(map[string]int64, len(names))
+	seekable := true
+	for _, name := range names {
+		if _, err := createPart(mw, name, fields[name]); err != nil {
+			return err
+		}
+		if size, ok := readerSize(fields[name]); ok && length >= 0 {
+			length += size
+		} else {
+			length = -1
+		}
+		if s, ok := fields[name].(io.Seeker); ok && seekable {
+			offset, err := s.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			offsets[name] = offset
+		} else {
+			seekable = false
+		}
+	}
+	mw.Close()
+
+	open := func() io.ReadCloser {
+		return &pipedBody{write: func(w io.Writer) error {
+			mw := multipart.NewWriter(w)
+			mw.SetBoundary(boundary)
+			for _, name := range names {
+				part, err := createPart(mw, name, fields[name])
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(part, fields[name]); err != nil {
+					return err
+				}
+			}
+			return mw.Close()
+		}}
+	}
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Body = open()
+	r.ContentLength = 0
+	if length >= 0 {
+		r.ContentLength = framing.n + length
+	}
+	r.GetBody = nil
+	if seekable {
+		r.GetBody = func() (io.ReadCloser, error) {
+			for _, name := range names {
+				if _, err := fields[name].(io.Seeker).Seek(offsets[name], io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+			return open(), nil
+		}
+	}
+	return nil
+}
+
+// createPart starts the part of the form written by mw for the field with the
+// given name and contents.
+func createPart(mw *multipart.Writer, name string, contents io.Reader) (io.Writer, error) {
+	if f, ok := contents.(*os.File); ok {
+		return mw.CreateFormFile(name, filepath.Base(f.Name()))
+	}
+	return mw.CreateFormField(name)
+}
+
+// readerSize returns the number of bytes left to read from rd, if known.
+func readerSize(rd io.Reader) (int64, bool) {
+	switch rd := rd.(type) {
+	case interface{ Len() int }:
+		return int64(rd.Len()), true
+	case *os.File:
+		info, err := rd.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+		offset, err := rd.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		return info.Size() - offset, true
+	}
+	return 0, false
+}
+
+// countingWriter counts the bytes written to it, discarding them.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// pipedBody is a request body streamed by write through a pipe, from the
+// first read on, so that a body that is never read holds no goroutine.
+type pipedBody struct {
+	write func(io.Writer) error
+
+	once sync.Once
+	pr   *io.PipeReader
+}
+
+func (b *pipedBody) start() {
+	b.once.Do(func() {
+		pr, pw := io.Pipe()
+		b.pr = pr
+		go func() {
+			pw.CloseWithError(b.write(pw))
+		}()
+	})
+}
+
+func (b *pipedBody) Read(p []byte) (int, error) {
+	b.start()
+	if b.pr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return b.pr.Read(p)
+}
+
+func (b *pipedBody) Close() error {
+	b.once.Do(func() {})
+	if b.pr == nil {
+		return nil
+	}
+	return b.pr.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parts reads the multipart form in the body of r and returns the contents
+// of its parts by form name, file parts being prefixed with their file name.
+func parts(t *testing.T, r *http.Request, body io.Reader) map[string]string {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() != "" {
+			data = append([]byte(part.FileName()+":"), data...)
+		}
+		got[part.FormName()] = string(data)
+	}
+}
+
+func TestNewMultipartRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte("a,b\n1,2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := NewMultipartRequest(context.Background(), http.MethodPost, "http://example.com/upload", map[string]io.Reader{
+		"title": strings.NewReader("Q3"),
+		"file":  f,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(body)) != r.ContentLength {
+		t.Fatalf("ContentLength = %d, want the %d bytes of the form", r.ContentLength, len(body))
+	}
+	want := map[string]string{"title": "Q3", "file": "report.csv:a,b\n1,2\n"}
+	if got := parts(t, r, strings.NewReader(string(body))); !reflect.DeepEqual(got, want) {
+		t.Fatalf("form = %q, want %q", got, want)
+	}
+
+	again, err := r.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if got := parts(t, r, again); !reflect.DeepEqual(got, want) {
+		t.Fatalf("form from GetBody = %q, want %q", got, want)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	var got map[string]string
+	var length int64
+	var rewindable bool
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got, length, rewindable = parts(t, r, r.Body), r.ContentLength, r.GetBody != nil
+		return newResponse(r, http.StatusOK, ""), nil
+	}), MultipartUpload(func(r *http.Request) map[string]io.Reader {
+		if r.URL.Path != "/upload" {
+			return nil
+		}
+		return map[string]io.Reader{"stream": unseekable("chunks")}
+	}))
+
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/upload", nil)).Body.Close()
+	if want := map[string]string{"stream": "chunks"}; !reflect.DeepEqual(got, want) || length != 0 || rewindable {
+		t.Fatalf("form = %q with length %d and GetBody %v, want %q of unknown length without GetBody", got, length, rewindable, want)
+	}
+
+	r := newRequest(t, http.MethodPost, "http://example.com/other", strings.NewReader("raw"))
+	c = Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(r.Body)
+		return newResponse(r, http.StatusOK, string(body)), nil
+	}), MultipartUpload(func(*http.Request) map[string]io.Reader { return nil }))
+	if got := bodyString(t, do(t, c, r)); got != "raw" {
+		t.Fatalf("body = %q, want it untouched", got)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Named returns a Decorator that labels the layer added by d with the given
+// name. Names show up in Chain and, while a request passes through the layer,
+// in LayersFromContext.
+func Named(name string, d Decorator) Decorator {
+	return func(c Client) Client {
+		return &layer{name: name, decorated: d(c), next: c}
+	}
+}
+
+// layer is a Client built by Decorate or Named. It holds the Client returned
+// by a Decorator along with the Client that Decorator decorates, so that the
+// chain can be walked even through Decorators returning a ClientFunc.
+type layer struct {
+	name      string
+	decorated Client
+	next      Client
+}
+
+// Do calls the decorated Client, recording the layer name in the request
+// context if it has one.
+func (l *layer) Do(r *http.Request) (*http.Response, error) {
+	if l.name == "" {
+		return l.decorated.Do(r)
+	}
+	layers := LayersFromContext(r.Context())
+	entered := make([]string, len(layers), len(layers)+1)
+	copy(entered, layers)
+	entered = append(entered, l.name)
+	ctx := context.WithValue(r.Context(), layersKey, entered)
+
+	trace, ok := ctx.Value(layerTraceKey).(*LayerTrace)
+	if !ok {
+		return l.decorated.Do(r.WithContext(ctx))
+	}
+	parent, _ := ctx.Value(layerFrameKey).(*layerFrame)
+	frame := &layerFrame{}
+	i := trace.enter(l.name)
+	start := time.Now()
+	defer func() {
+		total := time.Since(start)
+		trace.exit(i, total-time.Duration(frame.inner.Load()))
+		if parent != nil {
+			parent.inner.Add(int64(total))
+		}
+	}()
+	return l.decorated.Do(r.WithContext(context.WithValue(ctx, layerFrameKey, frame)))
+}
+
+// Unwrap returns the Client that the layer's Decorator decorates.
+func (l *layer) Unwrap() Client {
+	return l.next
+}
+
+// Chain returns the names of the Named layers of c, from outermost to
+// innermost. The chain is followed like Shutdown follows it.
+func Chain(c Client) []string {
+	var names []string
+	walk(c, func(c Client) {
+		if l, ok := c.(*layer); ok && l.name != "" {
+			names = append(names, l.name)
+		}
+	})
+	return names
+}
+
+// LayersFromContext returns the names of the Named layers a request has
+// entered so far, from outermost to innermost.
+func LayersFromContext(ctx context.Context) []string {
+	layers, _ := ctx.Value(layersKey).([]string)
+	return layers
+}
+
+// A LayerTiming is the time a request spent in a Named layer, not counting
+// the time spent in the Named layers it wraps.
+type LayerTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// A LayerTrace records the LayerTimings of a request.
+type LayerTrace struct {
+	mu      sync.Mutex
+	timings []LayerTiming
+}
+
+// ContextWithLayerTrace returns a copy of ctx carrying a new LayerTrace, in
+// which the Named layers record their timings for requests with that context.
+func ContextWithLayerTrace(ctx context.Context) (context.Context, *LayerTrace) {
+	trace := &LayerTrace{}
+	return context.WithValue(ctx, layerTraceKey, trace), trace
+}
+
+// Timings returns the timings recorded in t, from outermost to innermost layer.
+func (t *LayerTrace) Timings() []LayerTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]LayerTiming(nil), t.timings...)
+}
+
+// enter records that a request entered the named layer and returns the
+// index of its timing.
+func (t *LayerTrace) enter(name string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings = append(t.timings, LayerTiming{Name: name})
+	return len(t.timings) - 1
+}
+
+// exit records the duration of the i-th timing.
+func (t *LayerTrace) exit(i int, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings[i].Duration = d
+}
+
+// layerFrame accumulates the time a request spends in the Named layers
+// wrapped by the current one.
+type layerFrame struct {
+	inner atomic.Int64
+}
+
+// A LayerProfile adds up, across all the requests passing through its
+// Decorator, the time spent in each Named layer, like a flame graph does, to
+// find where the overhead of a chain goes.
+type LayerProfile struct {
+	mu     sync.Mutex
+	totals map[string]time.Duration
+}
+
+// NewLayerProfile returns an empty LayerProfile.
+func NewLayerProfile() *LayerProfile {
+	return &LayerProfile{totals: map[string]time.Duration{}}
+}
+
+// Decorator returns a Decorator that records in p the LayerTimings of every
+// request. It must come after the Named layers to profile in Decorate. A
+// LayerTrace already in the request context is recorded into as well.
+func (p *LayerProfile) Decorator() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			trace, ok := r.Context().Value(layerTraceKey).(*LayerTrace)
+			if !ok {
+				var ctx context.Context
+				ctx, trace = ContextWithLayerTrace(r.Context())
+				r = r.WithContext(ctx)
+			}
+			from := len(trace.Timings())
+			defer func() {
+				timings := trace.Timings()[from:]
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				for _, t := range timings {
+					p.totals[t.Name] += t.Duration
+				}
+			}()
+			return c.Do(r)
+		})
+	}
+}
+
+// Totals returns the time spent in each Named layer so far, by name.
+func (p *LayerProfile) Totals() map[string]time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	totals := make(map[string]time.Duration, len(p.totals))
+	for name, d := range p.totals {
+		totals[name] = d
+	}
+	return totals
+}
+
+// Reset discards the times recorded so far.
+func (p *LayerProfile) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totals = map[string]time.Duration{}
+}
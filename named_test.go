@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNamed(t *testing.T) {
+	var seen []string
+	inner := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		seen = LayersFromContext(r.Context())
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	identity := func(c Client) Client { return c }
+	c := Decorate(inner, Named("inner", identity), identity, Named("outer", identity))
+
+	if got, want := Chain(c), []string{"outer", "inner"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Chain() = %v, want %v", got, want)
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if want := []string{"outer", "inner"}; !reflect.DeepEqual(seen, want) {
+		t.Fatalf("LayersFromContext() = %v, want %v", seen, want)
+	}
+}
+
+// pause returns a Decorator that sleeps for d before passing requests on.
+func pause(d time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			time.Sleep(d)
+			return c.Do(r)
+		})
+	}
+}
+
+func TestLayerTrace(t *testing.T) {
+	c := Decorate(respond(http.StatusOK, ""), Named("inner", pause(20*time.Millisecond)), Named("outer", pause(10*time.Millisecond)))
+	ctx, trace := ContextWithLayerTrace(context.Background())
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)).Body.Close()
+
+	timings := trace.Timings()
+	if len(timings) != 2 || timings[0].Name != "outer" || timings[1].Name != "inner" {
+		t.Fatalf("Timings() = %v, want outer then inner", timings)
+	}
+	outer, inner := timings[0].Duration, timings[1].Duration
+	if outer < 10*time.Millisecond || inner < 20*time.Millisecond || outer >= inner+10*time.Millisecond {
+		t.Errorf("outer took %v and inner %v, want self times of about 10ms and 20ms", outer, inner)
+	}
+}
+
+func TestLayerProfile(t *testing.T) {
+	profile := NewLayerProfile()
+	c := Decorate(respond(http.StatusOK, ""), Named("inner", pause(10*time.Millisecond)), Named("outer", pause(5*time.Millisecond)), profile.Decorator())
+	for i := 0; i < 2; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	}
+	totals := profile.Totals()
+	if len(totals) != 2 || totals["inner"] < 20*time.Millisecond || totals["outer"] < 10*time.Millisecond || totals["outer"] >= totals["inner"] {
+		t.Fatalf("Totals() = %v, want about 20ms in inner and 10ms in outer", totals)
+	}
+
+	ctx, trace := ContextWithLayerTrace(context.Background())
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)).Body.Close()
+	if len(trace.Timings()) != 2 || profile.Totals()["inner"] < 30*time.Millisecond {
+		t.Fatalf("with a LayerTrace, recorded %v and totals %v, want both", trace.Timings(), profile.Totals())
+	}
+
+	profile.Reset()
+	if totals := profile.Totals(); len(totals) != 0 {
+		t.Fatalf("after Reset, Totals() = %v, want none", totals)
+	}
+}
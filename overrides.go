@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Overrides changes the parameters of some Decorators for a single request,
+// when carried by its context, see ContextWithOverrides. Zero fields keep the
+// parameters the Decorators were configured with.
+type Overrides struct {
+	// Attempts replaces the number of retries of the retrying Decorators.
+	// A negative value disables retries.
+	Attempts int
+	// Timeout replaces the timeout of HardTimeout Decorators.
+	Timeout time.Duration
+}
+
+// ContextWithOverrides returns a copy of ctx carrying the given Overrides.
+func ContextWithOverrides(ctx context.Context, o Overrides) context.Context {
+	return context.WithValue(ctx, overridesKey, o)
+}
+
+// OverridesFromContext returns the Overrides carried by ctx, if any.
+func OverridesFromContext(ctx context.Context) (Overrides, bool) {
+	o, ok := ctx.Value(overridesKey).(Overrides)
+	return o, ok
+}
+
+// overrideAttempts returns the number of retries for a request with the
+// given context, given the configured number.
+func overrideAttempts(ctx context.Context, attempts int) int {
+	o, _ := OverridesFromContext(ctx)
+	switch {
+	case o.Attempts < 0:
+		return 0
+	case o.Attempts > 0:
+		return o.Attempts
+	}
+	return attempts
+}
+
+// overrideTimeout returns the timeout for a request with the given context,
+// given the configured one.
+func overrideTimeout(ctx context.Context, d time.Duration) time.Duration {
+	if o, _ := OverridesFromContext(ctx); o.Timeout > 0 {
+		return o.Timeout
+	}
+	return d
+}
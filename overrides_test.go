@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOverridesAttempts(t *testing.T) {
+	for _, tc := range []struct {
+		overrides *Overrides
+		wantCalls int
+	}{
+		{nil, 3},
+		{&Overrides{}, 3},
+		{&Overrides{Attempts: 4}, 5},
+		{&Overrides{Attempts: -1}, 1},
+	} {
+		var calls int
+		c := Decorate(flaky(10, &calls), FaultTolerance(2, 0))
+		ctx := context.Background()
+		if tc.overrides != nil {
+			ctx = ContextWithOverrides(ctx, *tc.overrides)
+		}
+		c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx))
+		if calls != tc.wantCalls {
+			t.Errorf("overrides %+v: %d calls, want %d", tc.overrides, calls, tc.wantCalls)
+		}
+	}
+}
+
+func TestOverridesTimeout(t *testing.T) {
+	c := Decorate(slow(50*time.Millisecond), HardTimeout(time.Second))
+	ctx := ContextWithOverrides(context.Background(), Overrides{Timeout: 5 * time.Millisecond})
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)); !errors.Is(err, ErrHardTimeout) {
+		t.Fatalf("Do() error = %v, want %v", err, ErrHardTimeout)
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if o, ok := OverridesFromContext(ctx); !ok || o.Timeout != 5*time.Millisecond {
+		t.Fatalf("OverridesFromContext() = %+v, %v, want the Overrides", o, ok)
+	}
+}
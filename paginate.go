@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Paginate returns a Decorator that follows the Link rel="next" headers of
+// paginated responses, fetching up to maxPages pages in all, and returns the
+// response that combine builds out of them, in order. combine owns the pages
+// and must close the bodies it doesn't hand back. Combine it with If to only
+// paginate the endpoints that need it.
+func Paginate(maxPages int, combine func(pages []*http.Response) (*http.Response, error)) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil {
+				return res, err
+			}
+			pages := []*http.Response{res}
+			for len(pages) < maxPages {
+				next := nextLink(res)
+				if next == "" {
+					break
+				}
+				target, err := res.Request.URL.Parse(next)
+				if err != nil {
+					break
+				}
+				req := r.Clone(r.Context())
+				req.Method, req.URL, req.Host = http.MethodGet, target, ""
+				req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+				if res, err = c.Do(req); err != nil {
+					for _, page := range pages {
+						page.Body.Close()
+					}
+					return nil, err
+				}
+				pages = append(pages, res)
+			}
+			return combine(pages)
+		})
+	}
+}
+
+// nextLink returns the target of the Link rel="next" header of res, if any.
+func nextLink(res *http.Response) string {
+	if res.Request == nil {
+		return ""
+	}
+	for _, v := range res.Header.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(name, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
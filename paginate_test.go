@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// joinPages combines pages into a response whose body is their bodies joined
+// with commas.
+func joinPages(pages []*http.Response) (*http.Response, error) {
+	var bodies []string
+	for _, page := range pages {
+		body, err := io.ReadAll(page.Body)
+		page.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, string(body))
+	}
+	return newResponse(pages[0].Request, http.StatusOK, strings.Join(bodies, ",")), nil
+}
+
+func TestPaginate(t *testing.T) {
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		page := r.URL.Query().Get("page")
+		res := newResponse(r, http.StatusOK, "p"+page)
+		if page < "3" {
+			next := string(page[0] + 1)
+			res.Header.Add("Link", `</items?page=1>; rel="first"`)
+			res.Header.Add("Link", `</items?page=`+next+`>; rel="next last"`)
+		}
+		return res, nil
+	}), Paginate(10, joinPages))
+
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/items?page=1", nil))); got != "p1,p2,p3" {
+		t.Fatalf("body = %q, want p1,p2,p3", got)
+	}
+
+	c = Decorate(respond(http.StatusOK, "only"), Paginate(10, joinPages))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "only" {
+		t.Fatalf("without Link: body = %q, want only", got)
+	}
+}
+
+func TestPaginateStopsAtMaxPages(t *testing.T) {
+	var calls int
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		res := newResponse(r, http.StatusOK, "p")
+		res.Header.Set("Link", `<?more>; rel=next`)
+		return res, nil
+	}), Paginate(2, joinPages))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != "p,p" || calls != 2 {
+		t.Fatalf("body = %q after %d calls, want p,p after 2", got, calls)
+	}
+}
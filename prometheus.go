@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Metric is one of the default metric implementations of this package,
+// which PrometheusHandler can expose and MarshalMetrics can snapshot:
+// *AtomicCounter, *AtomicCounterVec, *AtomicGauge, *QuantileHistogram,
+// *QuantileHistogramVec and *BucketedHistogram.
+type Metric interface {
+	SnapshotMetric() MetricSnapshot
+	writePrometheus(w io.Writer)
+}
+
+// PrometheusHandler returns an http.Handler that serves the current values
+// of the given metrics in the Prometheus text exposition format, for them to
+// be scraped without the Prometheus client library. Counters and gauges are
+// exposed as such, QuantileHistograms as summaries and BucketedHistograms as
+// histograms. Metric and label names are sanitized, e.g. "client.requests"
+// becomes "client_requests".
+func PrometheusHandler(metrics ...Metric) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range metrics {
+			m.writePrometheus(w)
+		}
+	})
+}
+
+func (c *AtomicCounter) writePrometheus(w io.Writer) {
+	name := promName(c.name)
+	fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, c.Value())
+}
+
+func (v *AtomicCounterVec) writePrometheus(w io.Writer) {
+	name := promName(v.name)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range seriesKeys(v.labels) {
+		fmt.Fprintf(w, "%s%s %d\n", name, promLabels(v.labels[key]), v.counters[key].Value())
+	}
+}
+
+func (g *AtomicGauge) writePrometheus(w io.Writer) {
+	name := promName(g.name)
+	fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", name, name, g.Value())
+}
+
+func (h *QuantileHistogram) writePrometheus(w io.Writer) {
+	name := promName(h.name)
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	h.writeSummary(w, name, nil)
+}
+
+func (v *QuantileHistogramVec) writePrometheus(w io.Writer) {
+	name := promName(v.name)
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range seriesKeys(v.labels) {
+		v.histograms[key].writeSummary(w, name, v.labels[key])
+	}
+}
+
+// writeSummary writes the series of h as a summary with the given labels.
+func (h *QuantileHistogram) writeSummary(w io.Writer, name string, labels map[string]string) {
+	snapshot, count, sum := h.summary()
+	for i, q := range h.quantiles {
+		quantile := strconv.FormatFloat(float64(q)/100, 'g', -1, 64)
+		fmt.Fprintf(w, "%s%s %s\n", name, promLabels(labels, "quantile", quantile), promFloat(snapshot[i]))
+	}
+	fmt.Fprintf(w, "%s_sum%s %d\n", name, promLabels(labels), sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, promLabels(labels), count)
+}
+
+func (h *BucketedHistogram) writePrometheus(w io.Writer) {
+	name := promName(h.name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	counts := h.Counts()
+	var cumulative uint64
+	for i, count := range counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = promFloat(h.bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, promLabels(nil, "le", le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %d\n", name, h.Sum())
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+// promName turns name into a valid Prometheus metric or label name by
+// replacing every invalid character with an underscore.
+func promName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z',
+			r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// promLabels formats labels, followed by the given extra name and value
+// pairs, as a Prometheus label set, or an empty string if there are none.
+func promLabels(labels map[string]string, extra ...string) string {
+	var pairs []string
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pairs = append(pairs, promName(name)+`="`+promEscape(labels[name])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+promEscape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(value string) string {
+	return promEscaper.Replace(value)
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKeys returns the keys of the series of a metric vector, given their
+// labels, in increasing order.
+func seriesKeys(series map[string]map[string]string) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
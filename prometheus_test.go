@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	requests := NewCounterVec("client.requests")
+	requests.With(map[string]string{"method": "GET", "host": `a"b`}).Add(2)
+	requests.With(map[string]string{"method": "POST", "host": "a"}).Add(1)
+	inflight := NewGauge("client.active")
+	inflight.Add(3)
+	latency := NewHistogram("latency", 0, 1000, 0, 50, 100)
+	for _, v := range []int64{10, 20, 30, 40} {
+		latency.Observe(v)
+	}
+	sizes := NewBucketedHistogram("size", 10, 100)
+	for _, v := range []int64{5, 50, 500} {
+		sizes.Observe(v)
+	}
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler(requests, inflight, latency, sizes).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# TYPE client_requests counter
+client_requests{host="a",method="POST"} 1
+client_requests{host="a\"b",method="GET"} 2
+# TYPE client_active gauge
+client_active 3
+# TYPE latency summary
+latency{quantile="0.5"} 20
+latency{quantile="1"} 40
+latency_sum 100
+latency_count 4
+# TYPE size histogram
+size_bucket{le="10"} 1
+size_bucket{le="100"} 2
+size_bucket{le="+Inf"} 3
+size_sum 555
+size_count 3
+`
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("exposition =\n%s\nwant\n%s", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+}
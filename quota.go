@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Quota allows a limited number of requests within a rolling window of time.
+type Quota struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+// NewQuota returns a Quota of limit requests per window.
+func NewQuota(limit int, window time.Duration) *Quota {
+	return &Quota{limit: limit, window: window}
+}
+
+// Decorator returns a Decorator that fails a Client's requests with
+// ErrQuotaExceeded, without sending them, once q's limit has been reached
+// within the last window.
+func (q *Quota) Decorator() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if !q.take(clockFrom(r.Context()).Now()) {
+				return nil, fmt.Errorf("%w: %d requests per %s", ErrQuotaExceeded, q.limit, q.window)
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// Remaining returns how many more requests q allows right now, by the Clock
+// of ctx, as for requests with that context.
+func (q *Quota) Remaining(ctx context.Context) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(clockFrom(ctx).Now())
+	return q.limit - len(q.sent)
+}
+
+// take records a request at the given time, if q allows it.
+func (q *Quota) take(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(now)
+	if len(q.sent) >= q.limit {
+		return false
+	}
+	q.sent = append(q.sent, now)
+	return true
+}
+
+// prune forgets the requests that are out of the window ending at now.
+func (q *Quota) prune(now time.Time) {
+	i := 0
+	for i < len(q.sent) && now.Sub(q.sent[i]) >= q.window {
+		i++
+	}
+	q.sent = q.sent[i:]
+}
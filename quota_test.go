@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	clock := newFakeClock()
+	ctx := context.WithValue(context.Background(), clockKey, clock)
+	q := NewQuota(2, time.Minute)
+	c := Decorate(respond(http.StatusOK, ""), q.Decorator(), WithClock(clock))
+	send := func() error {
+		res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	send()
+	clock.Advance(30 * time.Second)
+	send()
+	if got := q.Remaining(ctx); got != 0 {
+		t.Fatalf("Remaining() = %d, want 0", got)
+	}
+	if err := send(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("over quota: Do() error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// The first request leaves the window.
+	clock.Advance(30 * time.Second)
+	if got := q.Remaining(ctx); got != 1 {
+		t.Fatalf("Remaining() = %d, want 1", got)
+	}
+	if err := send(); err != nil {
+		t.Fatalf("Do() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ResumeDownloads returns a Decorator that makes the body of every full GET
+// response from a server that accepts byte ranges survive up to attempts
+// broken connections: when reading the body fails, the rest of it is asked
+// for with a Range request and read on from there, so the caller sees one
+// uninterrupted body. The Range requests carry the ETag or Last-Modified of
+// the first response in If-Range, so that a changed resource is never
+// stitched onto the old one.
+func ResumeDownloads(attempts int) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil || r.Method != http.MethodGet || r.Header.Get("Range") != "" ||
+				res.StatusCode != http.StatusOK || res.Header.Get("Accept-Ranges") != "bytes" {
+				return res, err
+			}
+			validator := res.Header.Get("ETag")
+			if validator == "" || strings.HasPrefix(validator, "W/") {
+				validator = res.Header.Get("Last-Modified")
+			}
+			res.Body = &resumingBody{
+				client:    c,
+				req:       r,
+				validator: validator,
+				body:      res.Body,
+				attempts:  attempts,
+			}
+			return res, nil
+		})
+	}
+}
+
+// resumingBody is a response body that resumes reading with Range requests
+// after a failed read.
+type resumingBody struct {
+	client    Client
+	req       *http.Request
+	validator string
+	body      io.ReadCloser
+	read      int64
+	attempts  int
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.read += int64(n)
+		if err == nil || err == io.EOF || b.attempts <= 0 || b.req.Context().Err() != nil {
+			return n, err
+		}
+		b.attempts--
+		b.body.Close()
+		if b.body, err = b.resume(); err != nil {
+			b.body = http.NoBody
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume asks for the rest of the body, from the first byte not read yet.
+func (b *resumingBody) resume() (io.ReadCloser, error) {
+	r := b.req.Clone(b.req.Context())
+	r.Body = http.NoBody
+	r.Header.Set("Range", "bytes="+strconv.FormatInt(b.read, 10)+"-")
+	if b.validator != "" {
+		r.Header.Set("If-Range", b.validator)
+	}
+	res, err := b.client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("resuming download at byte %d: %w", b.read, err)
+	}
+	prefix := "bytes " + strconv.FormatInt(b.read, 10) + "-"
+	if res.StatusCode != http.StatusPartialContent || !strings.HasPrefix(res.Header.Get("Content-Range"), prefix) {
+		drainAndClose(res.Body)
+		return nil, fmt.Errorf("resuming download at byte %d: got %s, not the rest of the body", b.read, res.Status)
+	}
+	return res.Body, nil
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// download returns a Client serving content with the given ETag, whose
+// bodies break after every chunk bytes. It records the Range and If-Range
+// headers of every request in ranges.
+func download(content, etag string, chunk int, ranges *[]string) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		*ranges = append(*ranges, r.Header.Get("Range")+" "+r.Header.Get("If-Range"))
+		res := newResponse(r, http.StatusOK, "")
+		res.Header.Set("Accept-Ranges", "bytes")
+		res.Header.Set("ETag", etag)
+		from := 0
+		if rng := r.Header.Get("Range"); rng != "" {
+			if r.Header.Get("If-Range") != etag {
+				res.Body = io.NopCloser(strings.NewReader(content))
+				return res, nil
+			}
+			from, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			res.StatusCode, res.Status = http.StatusPartialContent, "206 Partial Content"
+			res.Header.Set("Content-Range", "bytes "+strconv.Itoa(from)+"-"+strconv.Itoa(len(content)-1)+"/"+strconv.Itoa(len(content)))
+		}
+		rest := content[from:]
+		if len(rest) > chunk {
+			res.Body = io.NopCloser(io.MultiReader(strings.NewReader(rest[:chunk]), iotest.ErrReader(io.ErrUnexpectedEOF)))
+		} else {
+			res.Body = io.NopCloser(strings.NewReader(rest))
+		}
+		return res, nil
+	})
+}
+
+func TestResumeDownloads(t *testing.T) {
+	var ranges []string
+	c := Decorate(download("hello, world", `"v1"`, 5, &ranges), ResumeDownloads(2))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/file", nil))); got != "hello, world" {
+		t.Fatalf("got body %q, want it whole", got)
+	}
+	if want := []string{" ", `bytes=5- "v1"`, `bytes=10- "v1"`}; !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("sent ranges %q, want %q", ranges, want)
+	}
+}
+
+func TestResumeDownloadsGivesUp(t *testing.T) {
+	var ranges []string
+	c := Decorate(download("hello, world", `"v1"`, 5, &ranges), ResumeDownloads(1))
+	res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/file", nil))
+	defer res.Body.Close()
+	if body, err := io.ReadAll(res.Body); err != io.ErrUnexpectedEOF || string(body) != "hello, wor" {
+		t.Fatalf("read %q, %v, want the first two chunks and %v", body, err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestResumeDownloadsChangedResource(t *testing.T) {
+	var ranges []string
+	first := download("hello, world", `"v1"`, 5, &ranges)
+	changed := download("HELLO, WORLD", `"v2"`, 5, &ranges)
+	calls := 0
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return first.Do(r)
+		}
+		return changed.Do(r)
+	}), ResumeDownloads(2))
+	res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/file", nil))
+	defer res.Body.Close()
+	if body, err := io.ReadAll(res.Body); err == nil || string(body) != "hello" {
+		t.Fatalf("read %q, %v, want the first chunk and an error", body, err)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitByKey returns a Decorator that limits a Client's requests to rps
+// per second, with bursts of up to burst requests, separately for each bucket
+// returned by key. Requests over the limit wait for their turn, or until
+// their context is done. A non-positive rps disables the limit. Buckets left
+// idle long enough to refill are dropped, so keys may be many and short-lived.
+func RateLimitByKey(key func(*http.Request) string, rps float64, burst int) Decorator {
+	return func(c Client) Client {
+		if rps <= 0 {
+			return c
+		}
+		buckets := &tokenBuckets{rate: rps, burst: burst, buckets: map[string]*tokenBucket{}}
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			defer observeWait(r, time.Now())
+			b, d := buckets.reserve(key(r), clockFrom(r.Context()).Now())
+			if err := waitTurn(r, d); err != nil {
+				b.cancel()
+				return nil, err
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// tokenBuckets holds token buckets by key. Buckets that are full are dropped
+// now and then, as they are no different from new ones.
+type tokenBuckets struct {
+	rate  float64
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// reserve takes a token from the bucket key, as tokenBucket.reserve does, and
+// returns the bucket along with how long to wait before using the token.
+func (s *tokenBuckets) reserve(key string, now time.Time) (*tokenBucket, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket(key, now)
+	return b, b.reserve(now)
+}
+
+// take takes a token from the bucket key, as tokenBucket.take does.
+func (s *tokenBuckets) take(key string, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bucket(key, now).take(now)
+}
+
+// bucket returns the bucket key, creating it if needed. Once a full refill
+// has passed since the last sweep, it first drops the buckets that are full.
+// s.mu must be held.
+func (s *tokenBuckets) bucket(key string, now time.Time) *tokenBucket {
+	if b, ok := s.buckets[key]; ok {
+		return b
+	}
+	if now.Sub(s.swept).Seconds()*s.rate >= float64(s.burst) {
+		for k, b := range s.buckets {
+			if b.full(now) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+	b := newTokenBucket(s.rate, s.burst)
+	s.buckets[key] = b
+	return b
+}
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes a token from the bucket and returns how long to wait before
+// it may be used.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refill adds the tokens accrued since the last refill. b.mu must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// spend takes n tokens from the bucket, going into debt if it has fewer.
+func (b *tokenBucket) spend(n float64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= n
+}
+
+// untilToken returns how long to wait before the bucket holds a whole token,
+// without taking it, or a negative duration if the bucket never refills.
+func (b *tokenBucket) untilToken(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return -1
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket holds as many tokens as it can.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// cancel gives back a token taken by reserve that won't be used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens++
+	b.mu.Unlock()
+}
+
+// waitTurn sleeps for d, as the rate limit of r requires, recording the wait
+// on the Span in r's context, if any.
+func waitTurn(r *http.Request, d time.Duration) error {
+	if d > 0 {
+		AddSpanEvent(r.Context(), "ratelimit.wait", map[string]string{"duration": d.String()})
+	}
+	return sleep(r.Context(), d)
+}
+
+// MinInterval returns a Decorator that spaces out the requests to each host
+// so that at least d elapses between the start of consecutive ones. Requests
+// wait for their turn, or until their context is done, in which case they
+// give their turn back unless a later request already took the next one.
+func MinInterval(d time.Duration) Decorator {
+	return func(c Client) Client {
+		var (
+			mu   sync.Mutex
+			next = map[string]time.Time{}
+		)
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			now := clockFrom(r.Context()).Now()
+			mu.Lock()
+			for host, at := range next {
+				if !at.After(now) {
+					delete(next, host)
+				}
+			}
+			start := next[r.URL.Host]
+			if start.Before(now) {
+				start = now
+			}
+			next[r.URL.Host] = start.Add(d)
+			mu.Unlock()
+
+			if err := waitTurn(r, start.Sub(now)); err != nil {
+				mu.Lock()
+				if next[r.URL.Host].Equal(start.Add(d)) {
+					next[r.URL.Host] = start
+				}
+				mu.Unlock()
+				return nil, err
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// CostBudget returns a Decorator that limits a Client's requests by the cost
+// each response reports in the given header, e.g. X-RateLimit-Cost, rather
+// than by their number. Costs are taken from a budget of up to budget units,
+// refilled at refill units per second, after each response; once the budget
+// runs out, requests wait until at least one unit is back, or until their
+// context is done. Without a positive refill, a spent budget is never back,
+// and requests fail with ErrRateLimited instead. Responses without a valid
+// cost cost nothing.
+func CostBudget(header string, budget, refill float64) Decorator {
+	return func(c Client) Client {
+		b := &tokenBucket{rate: refill, burst: budget, tokens: budget}
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			clock := clockFrom(r.Context())
+			for {
+				d := b.untilToken(clock.Now())
+				if d == 0 {
+					break
+				}
+				if d < 0 {
+					return nil, fmt.Errorf("%w: cost budget of %g spent", ErrRateLimited, budget)
+				}
+				if err := waitTurn(r, d); err != nil {
+					return nil, err
+				}
+			}
+			res, err := c.Do(r)
+			if err == nil {
+				if cost, perr := strconv.ParseFloat(res.Header.Get(header), 64); perr == nil && cost > 0 {
+					b.spend(cost, clock.Now())
+				}
+			}
+			return res, err
+		})
+	}
+}
+
+// ErrRateLimited is returned by a RateLimitDistributed Decorator for the
+// requests its DistributedLimiter denies, and by a CostBudget Decorator for
+// the requests over a budget that never refills.
+var ErrRateLimited = errors.New("rate limited")
+
+// A DistributedLimiter decides whether a request in the bucket key may be
+// sent now and, if not, how long to wait before asking again, e.g. from a
+// token bucket kept in Redis, so that a rate limit holds across instances.
+type DistributedLimiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// RateLimitDistributed returns a Decorator that sends requests only once
+// limiter allows them, in the bucket returned by key. Denied requests wait as
+// long as limiter suggests and ask again, unless the suggested wait is
+// longer than maxWait, or zero, in which case they fail with ErrRateLimited,
+// and unless their context is done first. Errors from limiter are returned as
+// they are.
+func RateLimitDistributed(limiter DistributedLimiter, key func(*http.Request) string, maxWait time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			k := key(r)
+			for {
+				ok, wait, err := limiter.Allow(r.Context(), k)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					return c.Do(r)
+				}
+				if wait <= 0 || wait > maxWait {
+					return nil, fmt.Errorf("%w: %q, retry in %s", ErrRateLimited, k, wait)
+				}
+				if err := waitTurn(r, wait); err != nil {
+					return nil, err
+				}
+			}
+		})
+	}
+}
+
+// MemoryLimiter is the in-memory DistributedLimiter returned by
+// NewMemoryLimiter, which only limits the requests of the process.
+type MemoryLimiter struct {
+	buckets *tokenBuckets
+}
+
+// NewMemoryLimiter returns a MemoryLimiter that allows rps requests per
+// second, with bursts of up to burst requests, in each bucket. A non-positive
+// rps disables the limit, and a burst below 1 allows bursts of 1. Buckets
+// left idle long enough to refill are dropped.
+func NewMemoryLimiter(rps float64, burst int) *MemoryLimiter {
+	if rps <= 0 {
+		return &MemoryLimiter{}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &MemoryLimiter{buckets: &tokenBuckets{rate: rps, burst: burst, buckets: map[string]*tokenBucket{}}}
+}
+
+// Allow takes a token from the bucket key if there is one, and otherwise
+// returns how long until there is.
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	if l.buckets == nil {
+		return true, 0, nil
+	}
+	d := l.buckets.take(key, clockFrom(ctx).Now())
+	return d == 0, d, nil
+}
+
+// take takes a token from the bucket if it holds a whole one, and otherwise
+// returns how long to wait before it does.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pathKey buckets requests by URL path.
+func pathKey(r *http.Request) string { return r.URL.Path }
+
+func TestRateLimitByKey(t *testing.T) {
+	clock := newFakeClock()
+	c := Decorate(respond(http.StatusOK, ""), RateLimitByKey(pathKey, 2, 2), WithClock(clock))
+	for _, path := range []string{"/a", "/a", "/a", "/b", "/a"} {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com"+path, nil)).Body.Close()
+	}
+	want := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+}
+
+func TestRateLimitByKeyWithoutRate(t *testing.T) {
+	clock := newFakeClock()
+	c := Decorate(respond(http.StatusOK, ""), RateLimitByKey(pathKey, 0, 1), WithClock(clock))
+	for i := 0; i < 3; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	}
+	if got := clock.Waits(); len(got) != 0 {
+		t.Fatalf("waited %v, want no limit", got)
+	}
+}
+
+func TestTokenBucketsDropsFullBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := &tokenBuckets{rate: 1, burst: 2, buckets: map[string]*tokenBucket{}}
+	s.reserve("a", now)
+	for i := 0; i < 3; i++ {
+		s.reserve("b", now)
+	}
+
+	// Two seconds refill a, but not b, which went a token into debt.
+	now = now.Add(2 * time.Second)
+	s.reserve("c", now)
+	if _, ok := s.buckets["a"]; ok {
+		t.Error("kept the full bucket a")
+	}
+	if _, ok := s.buckets["b"]; !ok {
+		t.Error("dropped the bucket b, which isn't full")
+	}
+	if len(s.buckets) != 2 {
+		t.Errorf("kept %d buckets, want 2", len(s.buckets))
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	clock := newFakeClock()
+	c := Decorate(respond(http.StatusOK, ""), MinInterval(time.Second), WithClock(clock))
+	for _, host := range []string{"a", "a", "b", "a"} {
+		do(t, c, newRequest(t, http.MethodGet, "http://"+host+"/", nil)).Body.Close()
+	}
+	// The fake clock moves on by every wait, so b goes right away and the
+	// third request to a waits a second after the second one.
+	want := []time.Duration{time.Second, time.Second}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+}
+
+func TestMinIntervalGivesBackCancelledTurns(t *testing.T) {
+	clock := newFakeClock()
+	c := Decorate(respond(http.StatusOK, ""), MinInterval(time.Second))
+	ctx := context.WithValue(context.Background(), clockKey, Clock(frozenClock{clock.Now()}))
+	do(t, c, newRequest(t, http.MethodGet, "http://a/", nil).WithContext(ctx)).Body.Close()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://a/", nil).WithContext(cancelled)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do() error = %v, want %v", err, context.Canceled)
+	}
+
+	// The next request gets the turn given back, a second after the first,
+	// rather than two.
+	ctx = context.WithValue(context.Background(), clockKey, clock)
+	do(t, c, newRequest(t, http.MethodGet, "http://a/", nil).WithContext(ctx)).Body.Close()
+	if got, want := clock.Waits(), []time.Duration{time.Second}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+}
+
+// frozenClock is a Clock stuck at a point in time, whose waits never end.
+type frozenClock struct {
+	now time.Time
+}
+
+func (c frozenClock) Now() time.Time                     { return c.now }
+func (frozenClock) After(time.Duration) <-chan time.Time { return nil }
+
+func TestCostBudget(t *testing.T) {
+	clock := newFakeClock()
+	for _, tc := range []struct {
+		refill float64
+		waits  []time.Duration
+		err    error
+	}{
+		{refill: 2, waits: []time.Duration{time.Second}},
+		{refill: 0, err: ErrRateLimited},
+	} {
+		var calls int
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			res := newResponse(r, http.StatusOK, "")
+			res.Header.Set("X-RateLimit-Cost", "3")
+			return res, nil
+		}), CostBudget("X-RateLimit-Cost", 2, tc.refill), WithClock(clock))
+
+		before := len(clock.Waits())
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+		res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("refill %v: second Do() error = %v, want %v", tc.refill, err, tc.err)
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+		if got := clock.Waits()[before:]; len(got) != len(tc.waits) || len(got) > 0 && got[0] != tc.waits[0] {
+			t.Fatalf("refill %v: waited %v, want %v", tc.refill, got, tc.waits)
+		}
+		if want := 1 + len(tc.waits); calls != want {
+			t.Fatalf("refill %v: sent %d requests, want %d", tc.refill, calls, want)
+		}
+	}
+}
+
+func TestRateLimitDistributed(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	c := Decorate(next, RateLimitDistributed(NewMemoryLimiter(2, 1), pathKey, time.Second), WithClock(clock))
+	for _, path := range []string{"/a", "/a", "/b"} {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com"+path, nil)).Body.Close()
+	}
+	if got, want := clock.Waits(), []time.Duration{500 * time.Millisecond}; calls != 3 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %d requests after waiting %v, want 3 after %v", calls, got, want)
+	}
+
+	c = Decorate(next, RateLimitDistributed(NewMemoryLimiter(0.5, 1), pathKey, time.Second), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/a", nil)).Body.Close()
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/a", nil)); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("with a wait over maxWait, Do() error = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestMemoryLimiterBurst(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	l := NewMemoryLimiter(2, 0)
+	c := Decorate(next, RateLimitDistributed(l, pathKey, time.Second), WithClock(clock))
+	for i := 0; i < 2; i++ {
+		do(t, c, newRequest(t, http.MethodGet, "http://example.com/a", nil)).Body.Close()
+	}
+	if got, want := clock.Waits(), []time.Duration{500 * time.Millisecond}; calls != 2 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %d requests after waiting %v, want 2 after %v", calls, got, want)
+	}
+
+	clock.Advance(time.Second)
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/b", nil)).Body.Close()
+	if _, ok := l.buckets.buckets["/a"]; ok {
+		t.Fatal("kept the refilled bucket /a")
+	}
+}
+
+func TestMemoryLimiterWithoutRate(t *testing.T) {
+	l := NewMemoryLimiter(0, 0)
+	for i := 0; i < 3; i++ {
+		if ok, wait, err := l.Allow(context.Background(), "k"); !ok || wait != 0 || err != nil {
+			t.Fatalf("Allow() = %v, %v, %v, want every request allowed", ok, wait, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrTooManyRedirects is returned by a FollowRedirects Decorator when a
+// request is redirected more times than allowed.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// ErrRedirectLoop is returned by a FollowRedirects Decorator when a request is
+// redirected back to a URL it was already sent to.
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// A RedirectPolicy is called with the request about to be sent for a
+// redirect and the requests made so far, oldest first. It may modify next,
+// for example to strip headers, or return an error to stop following.
+type RedirectPolicy func(next *http.Request, via []*http.Request) error
+
+// StripSensitiveHeaders is a RedirectPolicy that removes credentials from
+// requests redirected to a different host.
+func StripSensitiveHeaders(next *http.Request, via []*http.Request) error {
+	if next.URL.Host != via[0].URL.Host {
+		for _, h := range []string{"Authorization", "Proxy-Authorization", "Cookie", "Cookie2"} {
+			next.Header.Del(h)
+		}
+	}
+	return nil
+}
+
+// ErrRedirectToDisallowedHost is returned by a FollowRedirects Decorator with
+// an AllowRedirectHosts policy for redirects to hosts outside the allowlist.
+var ErrRedirectToDisallowedHost = errors.New("redirect to disallowed host")
+
+// AllowRedirectHosts returns a RedirectPolicy that stops following, with
+// ErrRedirectToDisallowedHost, redirects to hosts matching none of the given
+// patterns, which work as for AllowHosts. This keeps a redirect from leading a
+// request somewhere it could never have been sent directly.
+func AllowRedirectHosts(patterns ...string) RedirectPolicy {
+	return func(next *http.Request, via []*http.Request) error {
+		if !matchHost(patterns, next.URL.Hostname()) {
+			return fmt.Errorf("%w: %s", ErrRedirectToDisallowedHost, next.URL.Host)
+		}
+		return nil
+	}
+}
+
+// RedirectPolicies returns a RedirectPolicy that applies all the given
+// policies, in order, stopping at the first error.
+func RedirectPolicies(policies ...RedirectPolicy) RedirectPolicy {
+	return func(next *http.Request, via []*http.Request) error {
+		for _, policy := range policies {
+			if err := policy(next, via); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// FollowRedirects returns a Decorator that follows up to maxRedirects 3xx
+// responses to a Client's requests, carrying the request headers over to
+// each redirect as allowed by policy, which may be nil to carry them all.
+// The bodies of intermediate responses are closed. A redirect back to a URL
+// already visited with the same method fails with ErrRedirectLoop right away,
+// rather than once maxRedirects is reached.
+//
+// The underlying http.Client must not follow redirects itself, e.g. its
+// CheckRedirect must return http.ErrUseLastResponse; otherwise it never
+// returns the 3xx responses this Decorator acts upon.
+func FollowRedirects(maxRedirects int, policy RedirectPolicy) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			var via []*http.Request
+			for req := r; ; {
+				res, err := c.Do(req)
+				if err != nil {
+					return nil, err
+				}
+				loc := res.Header.Get("Location")
+				if !isRedirect(res.StatusCode) || loc == "" {
+					return res, nil
+				}
+				via = append(via, req)
+				if len(via) > maxRedirects {
+					drainAndClose(res.Body)
+					return nil, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, maxRedirects)
+				}
+
+				next, err := redirectRequest(req, res, loc)
+				if err != nil {
+					drainAndClose(res.Body)
+					return nil, err
+				}
+				if next == nil {
+					// The body can't be replayed, so the redirect is left to the caller.
+					return res, nil
+				}
+				drainAndClose(res.Body)
+				for _, prev := range via {
+					if prev.Method == next.Method && prev.URL.String() == next.URL.String() {
+						return nil, fmt.Errorf("%w: back to %s", ErrRedirectLoop, next.URL)
+					}
+				}
+				if policy != nil {
+					if err := policy(next, via); err != nil {
+						return nil, err
+					}
+				}
+				req = next
+			}
+		})
+	}
+}
+
+// isRedirect reports whether code is a redirect status with a Location.
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRequest returns the request that follows the redirect of req to
+// loc, with the method rewritten as browsers and net/http do. It returns nil
+// if req has a body that must be resent but can't be.
+func redirectRequest(req *http.Request, res *http.Response, loc string) (*http.Request, error) {
+	target, err := req.URL.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("redirect: invalid Location %q: %w", loc, err)
+	}
+
+	next := req.Clone(req.Context())
+	next.URL = target
+	next.Host = ""
+
+	switch res.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if req.GetBody != nil {
+			if next.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		} else if req.Body != nil && req.Body != http.NoBody {
+			return nil, nil
+		}
+	default:
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.Method = http.MethodGet
+		}
+		next.Body, next.GetBody, next.ContentLength = nil, nil, 0
+		next.Header.Del("Content-Type")
+		next.Header.Del("Content-Length")
+	}
+	return next, nil
+}
+
+// drainAndClose discards a bounded amount of what's left of body, so its
+// connection can be reused, and closes it.
+func drainAndClose(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, 4<<10)
+	body.Close()
+}
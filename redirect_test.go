@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// redirector answers requests to the URLs in locations with a 302 to the
+// matching location, and any other request with a 200 whose body is the
+// request method and URL. It records every request it gets in sent.
+func redirector(locations map[string]string, sent *[]*http.Request) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		*sent = append(*sent, r)
+		if loc, ok := locations[r.URL.String()]; ok {
+			res := newResponse(r, http.StatusFound, "")
+			res.Header.Set("Location", loc)
+			return res, nil
+		}
+		return newResponse(r, http.StatusOK, r.Method+" "+r.URL.String()), nil
+	})
+}
+
+func TestFollowRedirects(t *testing.T) {
+	var sent []*http.Request
+	c := Decorate(redirector(map[string]string{
+		"http://a.example/start": "/next",
+		"http://a.example/next":  "http://b.example/end",
+	}, &sent), FollowRedirects(5, StripSensitiveHeaders))
+
+	r := newRequest(t, http.MethodPost, "http://a.example/start", strings.NewReader("form"))
+	r.Header.Set("Authorization", "Bearer secret")
+	if got, want := bodyString(t, do(t, c, r)), "GET http://b.example/end"; got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(sent))
+	}
+	if got := sent[1].Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("same-host redirect: Authorization = %q, want it kept", got)
+	}
+	if got := sent[2].Header.Get("Authorization"); got != "" {
+		t.Errorf("cross-host redirect: Authorization = %q, want it stripped", got)
+	}
+}
+
+func TestFollowRedirectsStops(t *testing.T) {
+	var sent []*http.Request
+	c := Decorate(redirector(map[string]string{
+		"http://a.example/1": "/2",
+		"http://a.example/2": "/3",
+		"http://a.example/3": "/4",
+	}, &sent), FollowRedirects(1, nil))
+
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://a.example/1", nil))
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("Do() error = %v, want %v", err, ErrTooManyRedirects)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(sent))
+	}
+}
+
+func TestAllowRedirectHosts(t *testing.T) {
+	var sent []*http.Request
+	locations := map[string]string{
+		"http://a.example/start":     "http://cdn.b.example/next",
+		"http://cdn.b.example/next":  "http://evil.example/end",
+		"http://a.example/secret":    "http://cdn.b.example/public",
+		"http://cdn.b.example/other": "/",
+	}
+	policy := RedirectPolicies(StripSensitiveHeaders, AllowRedirectHosts("a.example", "*.b.example"))
+	c := Decorate(redirector(locations, &sent), FollowRedirects(5, policy))
+
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://a.example/start", nil))
+	if !errors.Is(err, ErrRedirectToDisallowedHost) || len(sent) != 2 {
+		t.Fatalf("Do() error = %v after %d requests, want %v after 2", err, len(sent), ErrRedirectToDisallowedHost)
+	}
+
+	sent = nil
+	r := newRequest(t, http.MethodGet, "http://a.example/secret", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if got := bodyString(t, do(t, c, r)); got != "GET http://cdn.b.example/public" {
+		t.Fatalf("body = %q, want the allowed redirect followed", got)
+	}
+	if got := sent[1].Header.Get("Authorization"); got != "" {
+		t.Fatalf("cross-host redirect: Authorization = %q, want every policy applied", got)
+	}
+}
+
+func TestFollowRedirectsLoop(t *testing.T) {
+	var sent []*http.Request
+	c := Decorate(redirector(map[string]string{
+		"http://a.example/1": "/2",
+		"http://a.example/2": "/1",
+	}, &sent), FollowRedirects(10, nil))
+
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://a.example/1", nil))
+	if !errors.Is(err, ErrRedirectLoop) || len(sent) != 2 {
+		t.Fatalf("Do() error = %v after %d requests, want %v after 2", err, len(sent), ErrRedirectLoop)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A RequestSummary describes a request sent through a RequestLog's Decorator.
+type RequestSummary struct {
+	Start    time.Time
+	Method   string
+	URL      string
+	Status   int
+	Duration time.Duration
+	Err      error
+}
+
+// A RequestLog keeps summaries of the last requests sent through its
+// Decorator, e.g. for an admin endpoint to render.
+type RequestLog struct {
+	mu      sync.Mutex
+	entries []RequestSummary
+	next    int
+	full    bool
+}
+
+// NewRequestLog returns a RequestLog that keeps the last n requests.
+func NewRequestLog(n int) *RequestLog {
+	return &RequestLog{entries: make([]RequestSummary, n)}
+}
+
+// Decorator returns a Decorator that records a summary of every request
+// a Client sends in l.
+func (l *RequestLog) Decorator() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			s := RequestSummary{Start: time.Now(), Method: r.Method, URL: r.URL.String()}
+			res, err := c.Do(r)
+			s.Duration, s.Err = time.Since(s.Start), err
+			if res != nil {
+				s.Status = res.StatusCode
+			}
+			l.add(s)
+			return res, err
+		})
+	}
+}
+
+// add records s, evicting the oldest summary if l is full.
+func (l *RequestLog) add(s RequestSummary) {
+	if len(l.entries) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = s
+	l.next = (l.next + 1) % len(l.entries)
+	l.full = l.full || l.next == 0
+}
+
+// Entries returns the summaries kept in l, oldest first.
+func (l *RequestLog) Entries() []RequestSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]RequestSummary(nil), l.entries[:l.next]...)
+	}
+	return append(append([]RequestSummary(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
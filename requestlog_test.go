@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRequestLog(t *testing.T) {
+	log := NewRequestLog(2)
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/fail" {
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusNoContent, ""), nil
+	}), log.Decorator())
+	if got := log.Entries(); len(got) != 0 {
+		t.Fatalf("Entries() = %v, want none", got)
+	}
+
+	for _, path := range []string{"/a", "/b", "/fail"} {
+		if res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com"+path, nil)); err == nil {
+			res.Body.Close()
+		}
+	}
+	var got []string
+	for _, s := range log.Entries() {
+		got = append(got, s.URL)
+	}
+	if want := []string{"http://example.com/b", "http://example.com/fail"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("kept %v, want %v", got, want)
+	}
+	if s := log.Entries()[0]; s.Status != http.StatusNoContent || s.Err != nil {
+		t.Errorf("first entry = %+v, want status 204 and no error", s)
+	}
+	if s := log.Entries()[1]; s.Status != 0 || s.Err != errFlaky {
+		t.Errorf("last entry = %+v, want error %v", s, errFlaky)
+	}
+}
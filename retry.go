@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header that marks a request as safe
+// to repeat even if its method isn't idempotent.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// FaultToleranceIdempotent returns a Decorator like FaultTolerance that only
+// retries idempotent requests: those with a GET, HEAD, OPTIONS, TRACE, PUT or
+// DELETE method, and any others carrying an IdempotencyKeyHeader.
+func FaultToleranceIdempotent(attempts int, backoff time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if !isIdempotent(r) {
+				return c.Do(r)
+			}
+			return retry(c, r, attempts, linear(backoff), failed)
+		})
+	}
+}
+
+// FaultToleranceClassified returns a Decorator like FaultTolerance that only
+// retries requests failing with errors for which retriable returns true.
+// A nil retriable defaults to TransientError.
+func FaultToleranceClassified(attempts int, backoff time.Duration, retriable func(error) bool) Decorator {
+	if retriable == nil {
+		retriable = TransientError
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(c, r, attempts, linear(backoff), func(_ *http.Response, err error) bool {
+				return err != nil && retriable(err)
+			})
+		})
+	}
+}
+
+// TransientError reports whether err is a transport failure likely to go away
+// when retried: a connection reset or refused, or a connection closed before
+// or while reading the response. DNS, TLS certificate and context errors are
+// never transient.
+func TransientError(err error) bool {
+	var (
+		dnsErr       *net.DNSError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+	)
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &dnsErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &invalidErr),
+		errors.As(err, &hostnameErr):
+		return false
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF):
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset by peer") ||
+		strings.Contains(msg, "unexpected EOF")
+}
+
+// FaultToleranceBalanced returns a Decorator like FaultTolerance that runs
+// the given Director on a fresh copy of the request before every attempt, so
+// that retries may go to a different backend than the attempts that failed.
+func FaultToleranceBalanced(attempts int, backoff time.Duration, dir Director) Decorator {
+	return func(c Client) Client {
+		balanced := ClientFunc(func(r *http.Request) (*http.Response, error) {
+			attempt := r.Clone(r.Context())
+			dir(attempt)
+			return c.Do(attempt)
+		})
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(balanced, r, attempts, linear(backoff), failed)
+		})
+	}
+}
+
+// FaultToleranceFailover returns a Decorator like FaultTolerance that sends
+// every attempt of an idempotent request to the next of the given backends,
+// in order, until one succeeds. It stops once every backend has been tried or
+// attempts retries have been made. Requests that aren't idempotent are sent to
+// the first backend only.
+func FaultToleranceFailover(attempts int, backoff time.Duration, backends ...string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if len(backends) == 0 {
+				return c.Do(r)
+			}
+			next := 0
+			failover := ClientFunc(func(r *http.Request) (*http.Response, error) {
+				attempt := r.Clone(r.Context())
+				attempt.URL.Host = backends[next]
+				next++
+				return c.Do(attempt)
+			})
+			if !isIdempotent(r) {
+				return failover.Do(r)
+			}
+			retries := overrideAttempts(r.Context(), attempts)
+			if retries > len(backends)-1 {
+				retries = len(backends) - 1
+			}
+			return retryLoop(failover, r, retries, linear(backoff), failed)
+		})
+	}
+}
+
+// RetryOnJSONError returns a Decorator that retries requests, up to attempts
+// times with the same backoff schedule as FaultTolerance, for as long as
+// isError reports that their response body holds an error, e.g. a 200 with
+// {"error":"rate_limited"}. Bodies larger than 10 MiB aren't inspected, and
+// bodies that fail to be read are retried. The body of the response returned
+// is left readable from the start.
+func RetryOnJSONError(attempts int, backoff time.Duration, isError func(body []byte) bool) Decorator {
+	return RetryOnJSONErrorWith(attempts, backoff, maxBufferedBody, isError)
+}
+
+// RetryOnJSONErrorWith returns a Decorator like RetryOnJSONError that
+// inspects bodies of up to maxBody bytes.
+func RetryOnJSONErrorWith(attempts int, backoff time.Duration, maxBody int64, isError func(body []byte) bool) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(c, r, attempts, linear(backoff), func(res *http.Response, err error) bool {
+				if err != nil {
+					return false
+				}
+				body, err := io.ReadAll(io.LimitReader(res.Body, maxBody+1))
+				res.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), res.Body), Closer: res.Body}
+				return err != nil || int64(len(body)) <= maxBody && isError(body)
+			})
+		})
+	}
+}
+
+// RateLimitResetHeader is the response header in which many APIs give, in
+// Unix seconds, the time at which a rate limit resets.
+const RateLimitResetHeader = "X-RateLimit-Reset"
+
+// RetryOnRateLimit returns a Decorator that retries requests, up to attempts
+// times, whose response is a 429 carrying a RateLimitResetHeader, waiting
+// until the reset time it gives before each retry. Responses with a reset
+// further away than maxWait are returned as they are, as are those without
+// the header or with a malformed one. Waits end early with the context error
+// once the request context is done.
+func RetryOnRateLimit(attempts int, maxWait time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			var wait time.Duration
+			return retry(c, r, attempts, func(int) time.Duration {
+				return wait
+			}, func(res *http.Response, err error) bool {
+				if err != nil || res.StatusCode != http.StatusTooManyRequests {
+					return false
+				}
+				reset, perr := strconv.ParseInt(res.Header.Get(RateLimitResetHeader), 10, 64)
+				if perr != nil {
+					return false
+				}
+				wait = time.Unix(reset, 0).Sub(clockFrom(r.Context()).Now())
+				return wait <= maxWait
+			})
+		})
+	}
+}
+
+// FaultTolerancePersistent returns a Decorator like FaultTolerance whose
+// exponential backoff is shared by all requests: every failed attempt doubles
+// it, starting from backoff and up to maxBackoff, and any successful one
+// resets it. This suits long-lived polling loops, where the delay should grow
+// over a streak of failures spanning several calls but not outlive it.
+func FaultTolerancePersistent(attempts int, backoff, maxBackoff time.Duration) Decorator {
+	var streak atomic.Int64
+	schedule := func(int) time.Duration {
+		d := backoff
+		for i := int64(1); i < streak.Load() && d < maxBackoff; i++ {
+			d *= 2
+		}
+		if d > maxBackoff {
+			return maxBackoff
+		}
+		return d
+	}
+	retryable := func(res *http.Response, err error) bool {
+		if err != nil {
+			streak.Add(1)
+			return true
+		}
+		streak.Store(0)
+		return false
+	}
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return retry(c, r, attempts, schedule, retryable)
+		})
+	}
+}
+
+// isIdempotent reports whether r can be sent more than once without
+// duplicating its side effects.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// retry sends r through c and retries it up to attempts times for as long as
+// retryable reports the outcome as a failure, sleeping backoff(n) before the
+// n-th retry. The bodies of failed responses that are retried are closed,
+// and the request body is rewound with GetBody, when set, before each retry.
+// It gives up early, returning the context error, if r's context is done
+// while sleeping, and doesn't retry at all if the RetryLimiter in r's
+// context, if any, is full, nor once the AttemptBudget in r's context, if any,
+// is spent. It stops retrying with ErrCircuitOpen once the
+// circuit breaker wrapping it, if any, opens. The Overrides in r's context,
+// if any, take precedence over attempts.
+func retry(c Client, r *http.Request, attempts int, backoff func(n int) time.Duration, retryable func(*http.Response, error) bool) (*http.Response, error) {
+	return retryLoop(c, r, overrideAttempts(r.Context(), attempts), backoff, retryable)
+}
+
+// retryLoop is retry without Overrides, for callers that apply them already.
+func retryLoop(c Client, r *http.Request, attempts int, backoff func(n int) time.Duration, retryable func(*http.Response, error) bool) (*http.Response, error) {
+	observer, _ := r.Context().Value(retryObserverKey).(*retryObserver)
+	limiter, _ := r.Context().Value(retryLimiterKey).(*RetryLimiter)
+	breaker, _ := r.Context().Value(breakerKey).(*circuitBreaker)
+	budget, _ := r.Context().Value(attemptBudgetKey).(*attemptBudget)
+	for n := 1; ; n++ {
+		res, err := c.Do(r)
+		if !retryable(res, err) {
+			return res, err
+		}
+		if n > attempts {
+			if observer != nil {
+				observer.exhausted.Add(1)
+			}
+			return res, err
+		}
+		if n == 1 && limiter != nil {
+			host := r.URL.Host
+			if !limiter.enter(host) {
+				return res, err
+			}
+			defer limiter.exit(host)
+		}
+		if budget != nil && budget.left.Add(-1) < 0 {
+			return res, err
+		}
+		if res != nil {
+			drainAndClose(res.Body)
+		}
+		if observer != nil {
+			observer.retries.Add(1)
+		}
+		AddSpanEvent(r.Context(), "retry", map[string]string{"attempt": strconv.Itoa(n + 1)})
+		if err := sleep(r.Context(), backoff(n)); err != nil {
+			return nil, err
+		}
+		if breaker != nil && breaker.open(clockFrom(r.Context()).Now()) {
+			return nil, fmt.Errorf("%w: gave up retrying after %d attempts", ErrCircuitOpen, n)
+		}
+		if err := rewind(r); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rewind resets the body of r for it to be sent again, if it has GetBody.
+func rewind(r *http.Request) error {
+	if r.GetBody == nil {
+		return nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// linear returns a backoff schedule that waits n times backoff before the
+// n-th retry.
+func linear(backoff time.Duration) func(n int) time.Duration {
+	return func(n int) time.Duration {
+		return backoff * time.Duration(n)
+	}
+}
+
+// jittered returns a backoff schedule that randomizes the delays of schedule
+// by up to the given fraction of them in either direction.
+func jittered(schedule func(n int) time.Duration, jitter float64) func(n int) time.Duration {
+	return func(n int) time.Duration {
+		d := float64(schedule(n))
+		return time.Duration(d + d*jitter*(2*rand.Float64()-1))
+	}
+}
+
+// failed is a retry condition that retries every request that errors.
+func failed(_ *http.Response, err error) bool {
+	return err != nil
+}
+
+// retryObserver holds the Counters of a RetryMetrics Decorator.
+type retryObserver struct {
+	retries, exhausted Counter
+}
+
+// RetryMetrics returns a Decorator that counts, for the retrying Decorators
+// it wraps, every retry in retries and every request that still fails once
+// its retries are exhausted in exhausted.
+func RetryMetrics(retries, exhausted Counter) Decorator {
+	observer := &retryObserver{retries: retries, exhausted: exhausted}
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			return c.Do(r.WithContext(context.WithValue(r.Context(), retryObserverKey, observer)))
+		}, retries, exhausted)
+	}
+}
+
+// AttemptBudget returns a Decorator that bounds the number of times each
+// request is sent, across all the retrying Decorators it wraps, to attempts,
+// however they are composed: every retry by any of them takes from the same
+// budget, and they stop retrying once it's spent.
+func AttemptBudget(attempts int) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			budget := &attemptBudget{}
+			budget.left.Store(int64(attempts - 1))
+			return c.Do(r.WithContext(context.WithValue(r.Context(), attemptBudgetKey, budget)))
+		})
+	}
+}
+
+// attemptBudget holds the retries left to a request by an AttemptBudget
+// Decorator.
+type attemptBudget struct {
+	left atomic.Int64
+}
+
+// A RetryLimiter caps how many requests can be retrying at once across the
+// retrying Decorators wrapped by its Decorator, so that retries can't multiply
+// the load on a struggling upstream. Requests that fail while it is full are
+// returned as they are, without being retried.
+type RetryLimiter struct {
+	max      int64
+	retrying atomic.Int64
+
+	// perHost, when set, makes max apply to each host on its own.
+	perHost bool
+	mu      sync.Mutex
+	hosts   map[string]int64
+}
+
+// NewRetryLimiter returns a RetryLimiter that lets up to max requests retry
+// at once.
+func NewRetryLimiter(max int) *RetryLimiter {
+	return &RetryLimiter{max: int64(max)}
+}
+
+// NewHostRetryLimiter returns a RetryLimiter that lets up to max requests to
+// each URL host retry at once, so that a struggling host can't take the
+// retries of the others.
+func NewHostRetryLimiter(max int) *RetryLimiter {
+	return &RetryLimiter{max: int64(max), perHost: true, hosts: map[string]int64{}}
+}
+
+// Decorator returns a Decorator that subjects the retrying Decorators it
+// wraps to l.
+func (l *RetryLimiter) Decorator() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			return c.Do(r.WithContext(context.WithValue(r.Context(), retryLimiterKey, l)))
+		})
+	}
+}
+
+// Retrying returns the number of requests currently retrying.
+func (l *RetryLimiter) Retrying() int {
+	return int(l.retrying.Load())
+}
+
+// RetryingHost returns the number of requests to host currently retrying.
+// It is always 0 unless l was returned by NewHostRetryLimiter.
+func (l *RetryLimiter) RetryingHost(host string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.hosts[host])
+}
+
+// enter counts a request to host that starts retrying, unless l is full.
+func (l *RetryLimiter) enter(host string) bool {
+	if l.perHost {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.hosts[host] >= l.max {
+			return false
+		}
+		l.hosts[host]++
+		l.retrying.Add(1)
+		return true
+	}
+	if l.retrying.Add(1) > l.max {
+		l.retrying.Add(-1)
+		return false
+	}
+	return true
+}
+
+// exit counts a request to host that is done retrying.
+func (l *RetryLimiter) exit(host string) {
+	if l.perHost {
+		l.mu.Lock()
+		if l.hosts[host]--; l.hosts[host] == 0 {
+			delete(l.hosts, host)
+		}
+		l.mu.Unlock()
+	}
+	l.retrying.Add(-1)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestRetryMetrics(t *testing.T) {
+	retries, exhausted := NewCounter("retries"), NewCounter("exhausted")
+	var calls int
+	c := Decorate(flaky(2, &calls), FaultTolerance(3, 0), RetryMetrics(retries, exhausted))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if retries.Value() != 2 || exhausted.Value() != 0 {
+		t.Fatalf("retries = %d and exhausted = %d, want 2 and 0", retries.Value(), exhausted.Value())
+	}
+
+	calls = 0
+	c = Decorate(flaky(5, &calls), FaultTolerance(1, 0), RetryMetrics(retries, exhausted))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != errFlaky {
+		t.Fatalf("Do() error = %v, want %v", err, errFlaky)
+	}
+	if retries.Value() != 3 || exhausted.Value() != 1 {
+		t.Fatalf("retries = %d and exhausted = %d, want 3 and 1", retries.Value(), exhausted.Value())
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	var calls int
+	c := Decorate(flaky(5, &calls), FaultTolerance(3, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx))
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("Do() error = %v after %d calls, want %v after 1", err, calls, context.Canceled)
+	}
+}
+
+func TestFaultToleranceIdempotent(t *testing.T) {
+	for _, tc := range []struct {
+		method    string
+		key       string
+		wantCalls int
+	}{
+		{http.MethodGet, "", 3},
+		{http.MethodDelete, "", 3},
+		{http.MethodPost, "", 1},
+		{http.MethodPost, "key-1", 3},
+	} {
+		var calls int
+		c := Decorate(flaky(5, &calls), FaultToleranceIdempotent(2, 0))
+		r := newRequest(t, tc.method, "http://example.com/", nil)
+		if tc.key != "" {
+			r.Header.Set(IdempotencyKeyHeader, tc.key)
+		}
+		c.Do(r)
+		if calls != tc.wantCalls {
+			t.Errorf("%s with key %q: %d calls, want %d", tc.method, tc.key, calls, tc.wantCalls)
+		}
+	}
+}
+
+func TestFaultToleranceBackoff(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	c := Decorate(flaky(3, &calls), FaultTolerance(3, time.Second), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+}
+
+func TestFaultToleranceJittered(t *testing.T) {
+	clock := newFakeClock()
+	var calls int
+	c := Decorate(flaky(20, &calls), FaultToleranceJittered(20, time.Second, 0.1), WithClock(clock))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	distinct := map[time.Duration]bool{}
+	for i, d := range clock.Waits() {
+		n := time.Duration(i + 1)
+		if d < n*900*time.Millisecond || d > n*1100*time.Millisecond {
+			t.Fatalf("retry %d waited %v, want within 10%% of %v", i+1, d, n*time.Second)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatal("every backoff is the same, want them randomized")
+	}
+}
+
+func TestTransientError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("read tcp: connection reset by peer"), true},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, false},
+		{fmt.Errorf("tls: %w", x509.UnknownAuthorityError{}), false},
+		{context.DeadlineExceeded, false},
+		{errFlaky, false},
+	} {
+		if got := TransientError(tc.err); got != tc.want {
+			t.Errorf("TransientError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestFaultToleranceClassified(t *testing.T) {
+	var calls int
+	c := Decorate(flaky(5, &calls), FaultToleranceClassified(2, 0, nil))
+	c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if calls != 1 {
+		t.Fatalf("not transient: %d calls, want 1", calls)
+	}
+
+	calls = 0
+	c = Decorate(flaky(5, &calls), FaultToleranceClassified(2, 0, func(err error) bool { return err == errFlaky }))
+	c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if calls != 3 {
+		t.Fatalf("retriable: %d calls, want 3", calls)
+	}
+}
+
+func TestFaultToleranceBalanced(t *testing.T) {
+	var hosts []string
+	var calls int
+	inner := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		return flaky(2, &calls).Do(r)
+	})
+	c := Decorate(inner, FaultToleranceBalanced(2, 0, RoundRobin(0, "b1", "b2")))
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	do(t, c, r).Body.Close()
+	if want := []string{"b2", "b1", "b2"}; !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("sent to %v, want %v", hosts, want)
+	}
+	if r.URL.Host != "example.com" {
+		t.Fatalf("caller's request sent to %q, want it left alone", r.URL.Host)
+	}
+}
+
+func TestFaultToleranceFailover(t *testing.T) {
+	for _, tc := range []struct {
+		method   string
+		attempts int
+		want     []string
+	}{
+		{http.MethodGet, 5, []string{"b1", "b2", "b3"}},
+		{http.MethodGet, 1, []string{"b1", "b2"}},
+		{http.MethodPost, 5, []string{"b1"}},
+	} {
+		var hosts []string
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			hosts = append(hosts, r.URL.Host)
+			return nil, errFlaky
+		}), FaultToleranceFailover(tc.attempts, 0, "b1", "b2", "b3"))
+		c.Do(newRequest(t, tc.method, "http://example.com/", nil))
+		if !reflect.DeepEqual(hosts, tc.want) {
+			t.Errorf("%s with %d attempts: sent to %v, want %v", tc.method, tc.attempts, hosts, tc.want)
+		}
+	}
+}
+
+// answers returns a Client that answers its requests with the given bodies in
+// turn, counting them in calls.
+func answers(calls *int, bodies ...io.Reader) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, "")
+		res.Body = io.NopCloser(bodies[*calls])
+		*calls++
+		return res, nil
+	})
+}
+
+func TestRetryOnJSONError(t *testing.T) {
+	isError := func(body []byte) bool { return strings.Contains(string(body), `"error"`) }
+
+	var calls int
+	c := Decorate(answers(&calls,
+		strings.NewReader(`{"error":"rate_limited"}`),
+		iotest.ErrReader(errFlaky),
+		strings.NewReader(`{"ok":true}`),
+	), RetryOnJSONError(3, 0, isError))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != `{"ok":true}` || calls != 3 {
+		t.Fatalf("got %q after %d calls, want the third body", got, calls)
+	}
+
+	calls = 0
+	large := `{"error":"` + strings.Repeat("x", 64) + `"}`
+	c = Decorate(answers(&calls, strings.NewReader(large)), RetryOnJSONErrorWith(3, 0, 64, isError))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))); got != large || calls != 1 {
+		t.Fatalf("got %q after %d calls, want the large body untouched", got, calls)
+	}
+}
+
+func TestRetryLimiter(t *testing.T) {
+	retried, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	calls := map[string]int{}
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+		if n == 1 {
+			return nil, errFlaky
+		}
+		if r.URL.Path == "/held" {
+			close(retried)
+			<-release
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	limiter := NewRetryLimiter(1)
+	c := Decorate(next, FaultTolerance(2, 0), limiter.Decorator())
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/held", nil))
+		done <- err
+	}()
+	<-retried
+	if got := limiter.Retrying(); got != 1 {
+		t.Fatalf("Retrying() = %d, want 1", got)
+	}
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/other", nil)); err != errFlaky {
+		t.Fatalf("while full, Do() error = %v, want %v without a retry", err, errFlaky)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := limiter.Retrying(); got != 0 {
+		t.Fatalf("after the retries, Retrying() = %d, want 0", got)
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/later", nil)).Body.Close()
+	if calls["/later"] != 2 {
+		t.Fatalf("once free, /later got %d calls, want 2", calls["/later"])
+	}
+}
+
+func TestHostRetryLimiter(t *testing.T) {
+	retried, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	calls := map[string]int{}
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		calls[r.URL.Host+r.URL.Path]++
+		n := calls[r.URL.Host+r.URL.Path]
+		mu.Unlock()
+		if n == 1 {
+			return nil, errFlaky
+		}
+		if r.URL.Path == "/held" {
+			close(retried)
+			<-release
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	limiter := NewHostRetryLimiter(1)
+	c := Decorate(next, FaultTolerance(2, 0), limiter.Decorator())
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Do(newRequest(t, http.MethodGet, "http://a.example/held", nil))
+		done <- err
+	}()
+	<-retried
+	if a, b := limiter.RetryingHost("a.example"), limiter.RetryingHost("b.example"); a != 1 || b != 0 {
+		t.Fatalf("RetryingHost() = %d for a.example and %d for b.example, want 1 and 0", a, b)
+	}
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://a.example/other", nil)); err != errFlaky {
+		t.Fatalf("with a.example full, Do() error = %v, want %v without a retry", err, errFlaky)
+	}
+	do(t, c, newRequest(t, http.MethodGet, "http://b.example/other", nil)).Body.Close()
+	if calls["b.example/other"] != 2 {
+		t.Fatalf("b.example/other got %d calls, want 2", calls["b.example/other"])
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := limiter.Retrying(); got != 0 || limiter.RetryingHost("a.example") != 0 {
+		t.Fatalf("after the retries, Retrying() = %d, want 0", got)
+	}
+}
+
+func TestFaultTolerancePersistent(t *testing.T) {
+	clock := newFakeClock()
+	failures := 0
+	next := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if failures > 0 {
+			failures--
+			return nil, errFlaky
+		}
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	c := Decorate(next, FaultTolerancePersistent(2, time.Second, 4*time.Second), WithClock(clock))
+	get := func() error {
+		res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	failures = 5
+	if err := get(); err != errFlaky {
+		t.Fatalf("Do() error = %v, want %v", err, errFlaky)
+	}
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	failures = 1
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Second}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+}
+
+func TestRetryOnRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	var resets []string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if len(resets) == 0 {
+			return newResponse(r, http.StatusOK, "ok"), nil
+		}
+		res := newResponse(r, http.StatusTooManyRequests, "")
+		res.Header.Set(RateLimitResetHeader, resets[0])
+		resets = resets[1:]
+		return res, nil
+	}), RetryOnRateLimit(3, time.Minute), WithClock(clock))
+	in := func(d time.Duration) string {
+		return strconv.FormatInt(clock.Now().Add(d).Unix(), 10)
+	}
+
+	resets = []string{in(2 * time.Second)}
+	if res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)); res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want the retry's 200", res.StatusCode)
+	}
+	if got, want := clock.Waits(), []time.Duration{2 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+
+	for _, reset := range []string{in(time.Hour), "soon"} {
+		resets = []string{reset}
+		if res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)); res.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("reset %q: status = %d, want the 429 returned", reset, res.StatusCode)
+		}
+	}
+	if len(clock.Waits()) != 1 {
+		t.Fatalf("waited %v, want no more waits", clock.Waits())
+	}
+}
+
+func TestAttemptBudget(t *testing.T) {
+	var calls int
+	nested := Decorate(flaky(100, &calls), FaultTolerance(3, 0), FaultTolerance(3, 0))
+	if _, err := nested.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != errFlaky || calls != 16 {
+		t.Fatalf("without a budget, got %v after %d calls, want %v after 16", err, calls, errFlaky)
+	}
+
+	calls = 0
+	c := Decorate(nested, AttemptBudget(5))
+	for i := 0; i < 2; i++ {
+		if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != errFlaky || calls != 5*(i+1) {
+			t.Fatalf("request %d got %v after %d calls in all, want %v after %d", i, err, calls, errFlaky, 5*(i+1))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RewriteAPIVersion returns a Decorator that replaces the first path segment
+// of every request equal to from with to, e.g. "v1" with "v2" to send
+// /api/v1/users to /api/v2/users. Requests without such a segment are sent
+// as they are. Surrounding slashes in from and to are ignored.
+func RewriteAPIVersion(from, to string) Decorator {
+	from, to = strings.Trim(from, "/"), strings.Trim(to, "/")
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if path, ok := replaceSegment(r.URL.Path, from, to); ok {
+				r.URL.Path = path
+				if r.URL.RawPath != "" {
+					r.URL.RawPath, _ = replaceSegment(r.URL.RawPath, from, to)
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// replaceSegment replaces the first segment of path equal to from with to.
+func replaceSegment(path, from, to string) (string, bool) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == from {
+			segments[i] = to
+			return strings.Join(segments, "/"), true
+		}
+	}
+	return path, false
+}
+
+// CanonicalizeQuery returns a Decorator that sorts the query parameters of
+// every request URL by name, so that signatures over the URL, like those of
+// SignEd25519, don't depend on the order the parameters were added in. The
+// values of a repeated parameter keep their relative order, and every
+// parameter keeps its original encoding. It must wrap the signing Decorator,
+// i.e. come after it in Decorate, to run before it.
+func CanonicalizeQuery() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.RawQuery != "" {
+				pairs := strings.Split(r.URL.RawQuery, "&")
+				sort.SliceStable(pairs, func(i, j int) bool {
+					ki, _, _ := strings.Cut(pairs[i], "=")
+					kj, _, _ := strings.Cut(pairs[j], "=")
+					return ki < kj
+				})
+				r.URL.RawQuery = strings.Join(pairs, "&")
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// NormalizeHost returns a Decorator that lowercases the host of every request
+// URL and strips its port when it is the default one for the scheme, :80 for
+// http and :443 for https, so that the Decorators it wraps, like caches and
+// sticky Directors, see a single form of each host.
+func NormalizeHost() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			host := strings.ToLower(r.URL.Host)
+			switch {
+			case r.URL.Scheme == "http" && strings.HasSuffix(host, ":80"):
+				host = strings.TrimSuffix(host, ":80")
+			case r.URL.Scheme == "https" && strings.HasSuffix(host, ":443"):
+				host = strings.TrimSuffix(host, ":443")
+			}
+			r.URL.Host = host
+			return c.Do(r)
+		})
+	}
+}
+
+// Transform returns a Decorator that applies the given request transforms to
+// every request, in order, before sending it. The first transform to fail
+// aborts the request with its error.
+func Transform(fns ...func(*http.Request) error) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			for _, fn := range fns {
+				if err := fn(r); err != nil {
+					return nil, err
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// TransformResponse returns a Decorator that passes every response received
+// to fn and returns what fn returns in its place, e.g. to rewrite its status,
+// headers or body. Errors are returned as they are, without calling fn. fn
+// owns the response, and must close its body if it returns another one or an
+// error.
+func TransformResponse(fn func(*http.Response) (*http.Response, error)) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil {
+				return res, err
+			}
+			return fn(res)
+		})
+	}
+}
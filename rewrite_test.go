@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// echoURL returns a Client that answers every request with its URL.
+func echoURL() Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		return newResponse(r, http.StatusOK, r.URL.String()), nil
+	})
+}
+
+func TestRewriteAPIVersion(t *testing.T) {
+	c := Decorate(echoURL(), RewriteAPIVersion("/v1/", "v2"))
+	for url, want := range map[string]string{
+		"http://example.com/api/v1/users":      "http://example.com/api/v2/users",
+		"http://example.com/v1/v1":             "http://example.com/v2/v1",
+		"http://example.com/api/v10/users":     "http://example.com/api/v10/users",
+		"http://example.com/v1/a%2Fb?q=v1":     "http://example.com/v2/a%2Fb?q=v1",
+		"http://example.com/api/users?version": "http://example.com/api/users?version",
+	} {
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, url, nil))); got != want {
+			t.Errorf("%s was sent to %s, want %s", url, got, want)
+		}
+	}
+}
+
+func TestCanonicalizeQuery(t *testing.T) {
+	c := Decorate(echoURL(), CanonicalizeQuery())
+	for url, want := range map[string]string{
+		"http://example.com/?b=2&a=1&c=3":          "http://example.com/?a=1&b=2&c=3",
+		"http://example.com/?tag=z&id=1&tag=a":     "http://example.com/?id=1&tag=z&tag=a",
+		"http://example.com/?q=a%20b&flag&a=x%2By": "http://example.com/?a=x%2By&flag&q=a%20b",
+		"http://example.com/path":                  "http://example.com/path",
+	} {
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, url, nil))); got != want {
+			t.Errorf("%s was sent to %s, want %s", url, got, want)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	c := Decorate(echoURL(), NormalizeHost())
+	for url, want := range map[string]string{
+		"http://Example.COM:80/a":    "http://example.com/a",
+		"https://example.com:443/a":  "https://example.com/a",
+		"http://example.com:443/a":   "http://example.com:443/a",
+		"https://example.com:8443/a": "https://example.com:8443/a",
+		"http://[::1]:80/a":          "http://[::1]/a",
+	} {
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, url, nil))); got != want {
+			t.Errorf("%s was sent to %s, want %s", url, got, want)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	var ran []string
+	step := func(name string, err error) func(*http.Request) error {
+		return func(r *http.Request) error {
+			ran = append(ran, name)
+			r.URL.Path += "/" + name
+			return err
+		}
+	}
+	c := Decorate(echoURL(), Transform(step("a", nil), step("b", nil)))
+	if got := bodyString(t, do(t, c, newRequest(t, http.MethodGet, "http://example.com", nil))); got != "http://example.com/a/b" {
+		t.Fatalf("sent to %s, want both transforms applied in order", got)
+	}
+
+	ran = nil
+	c = Decorate(echoURL(), Transform(step("a", errFlaky), step("b", nil)))
+	if _, err := c.Do(newRequest(t, http.MethodGet, "http://example.com", nil)); err != errFlaky || len(ran) != 1 {
+		t.Fatalf("Do() error = %v after transforms %v, want %v after a only", err, ran, errFlaky)
+	}
+}
+
+func TestTransformResponse(t *testing.T) {
+	var calls int
+	upper := TransformResponse(func(res *http.Response) (*http.Response, error) {
+		calls++
+		res.StatusCode = http.StatusAccepted
+		res.Header.Set("X-Transformed", "yes")
+		return res, nil
+	})
+	res := do(t, Decorate(echoURL(), upper), newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if got := bodyString(t, res); res.StatusCode != http.StatusAccepted || res.Header.Get("X-Transformed") != "yes" || got != "http://example.com/" {
+		t.Fatalf("response = %d %v %q, want it transformed", res.StatusCode, res.Header, got)
+	}
+
+	failing := ClientFunc(func(*http.Request) (*http.Response, error) { return nil, errFlaky })
+	if _, err := Decorate(failing, upper).Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != errFlaky || calls != 1 {
+		t.Fatalf("Do() error = %v after %d transforms, want %v without a transform", err, calls, errFlaky)
+	}
+
+	reject := TransformResponse(func(res *http.Response) (*http.Response, error) {
+		res.Body.Close()
+		return nil, errFlaky
+	})
+	if _, err := Decorate(echoURL(), reject).Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); err != errFlaky {
+		t.Fatalf("Do() error = %v, want the transform's %v", err, errFlaky)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrQuorumNotMet is returned by a ScatterGather Decorator when too few
+// backends answer successfully.
+var ErrQuorumNotMet = errors.New("quorum not met")
+
+// ScatterGather returns a Decorator that sends every request to all the given
+// backends at once and returns the response that combine builds out of the
+// successful responses, in the order of their backends. A backend fails if
+// it errors or answers with a 5xx; as long as at least quorum backends
+// succeed the others are ignored, otherwise the request fails with
+// ErrQuorumNotMet, as do all requests, without being sent, if quorum is more
+// than the number of backends. combine owns the responses and must close the
+// bodies it doesn't hand back.
+func ScatterGather(quorum int, combine func(responses []*http.Response) (*http.Response, error), backends ...string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if len(backends) == 0 {
+				return c.Do(r)
+			}
+			if quorum > len(backends) {
+				return nil, fmt.Errorf("%w: need %d of only %d backends", ErrQuorumNotMet, quorum, len(backends))
+			}
+			if _, err := readBody(r); err != nil {
+				return nil, err
+			}
+			responses := make([]*http.Response, len(backends))
+			errs := make([]error, len(backends))
+			var wg sync.WaitGroup
+			for i, backend := range backends {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req := r.Clone(r.Context())
+					req.URL.Host = backend
+					if r.GetBody != nil {
+						if req.Body, errs[i] = r.GetBody(); errs[i] != nil {
+							return
+						}
+					}
+					res, err := c.Do(req)
+					switch {
+					case err != nil:
+						errs[i] = fmt.Errorf("%s: %w", backend, err)
+					case res.StatusCode >= 500:
+						drainAndClose(res.Body)
+						errs[i] = fmt.Errorf("%s: http status %s", backend, res.Status)
+					default:
+						responses[i] = res
+					}
+				}()
+			}
+			wg.Wait()
+
+			var succeeded []*http.Response
+			for _, res := range responses {
+				if res != nil {
+					succeeded = append(succeeded, res)
+				}
+			}
+			if len(succeeded) < quorum || len(succeeded) == 0 {
+				// At least one backend failed, so errs holds an error.
+				for _, res := range succeeded {
+					drainAndClose(res.Body)
+				}
+				return nil, fmt.Errorf("%w: %d of %d backends succeeded, need %d: %w",
+					ErrQuorumNotMet, len(succeeded), len(backends), quorum, errors.Join(errs...))
+			}
+			return combine(succeeded)
+		})
+	}
+}
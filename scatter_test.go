@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// backendsAnswering returns a Client that answers every request with its host
+// as body, with the given status per host, and records the bodies it gets.
+func backendsAnswering(statuses map[string]int, mu *sync.Mutex, sent *[]string) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				return nil, err
+			}
+		}
+		mu.Lock()
+		*sent = append(*sent, string(body))
+		mu.Unlock()
+		status, ok := statuses[r.URL.Host]
+		if !ok {
+			return nil, errFlaky
+		}
+		return newResponse(r, status, r.URL.Host), nil
+	})
+}
+
+// joinHosts combines responses into one whose body joins theirs.
+func joinHosts(responses []*http.Response) (*http.Response, error) {
+	var hosts []string
+	for _, res := range responses {
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, string(body))
+	}
+	return newResponse(responses[0].Request, http.StatusOK, strings.Join(hosts, ",")), nil
+}
+
+func TestScatterGather(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	next := backendsAnswering(map[string]int{"a": http.StatusOK, "b": http.StatusBadGateway, "c": http.StatusOK}, &mu, &sent)
+
+	c := Decorate(next, ScatterGather(2, joinHosts, "a", "b", "c", "d"))
+	res := do(t, c, newRequest(t, http.MethodPost, "http://example.com/", unseekable("query")))
+	if got := bodyString(t, res); got != "a,c" {
+		t.Fatalf("got %q, want the successful responses in backend order", got)
+	}
+	if len(sent) != 4 || sent[0] != "query" || sent[3] != "query" {
+		t.Fatalf("backends got bodies %q, want the request body each", sent)
+	}
+
+	c = Decorate(next, ScatterGather(3, joinHosts, "a", "b", "c", "d"))
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if !errors.Is(err, ErrQuorumNotMet) || !errors.Is(err, errFlaky) {
+		t.Fatalf("Do() error = %v, want %v along with the backend errors", err, ErrQuorumNotMet)
+	}
+}
+
+func TestScatterGatherImpossibleQuorum(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	next := backendsAnswering(map[string]int{"a": http.StatusOK}, &mu, &sent)
+	c := Decorate(next, ScatterGather(2, joinHosts, "a"))
+	_, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if !errors.Is(err, ErrQuorumNotMet) || strings.Contains(err.Error(), "%!") || len(sent) != 0 {
+		t.Fatalf("Do() error = %v after %d requests, want %v without sending any", err, len(sent), ErrQuorumNotMet)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInsecureTLS is returned by a RequireTLSVersion Decorator for responses
+// received without TLS or over an older TLS version than required.
+var ErrInsecureTLS = errors.New("insecure TLS connection")
+
+// RequireTLSVersion returns a Decorator that rejects responses that weren't
+// received over TLS of at least version min, e.g. tls.VersionTLS12, closing
+// their body and returning ErrInsecureTLS.
+func RequireTLSVersion(min uint16) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil {
+				return res, err
+			}
+			if res.TLS == nil {
+				res.Body.Close()
+				return nil, fmt.Errorf("%w: %s received without TLS", ErrInsecureTLS, r.URL)
+			}
+			if res.TLS.Version < min {
+				res.Body.Close()
+				return nil, fmt.Errorf("%w: %s received over %s, need %s", ErrInsecureTLS,
+					r.URL, tls.VersionName(res.TLS.Version), tls.VersionName(min))
+			}
+			return res, nil
+		})
+	}
+}
+
+// ForceHTTPS returns a Decorator that upgrades every http request to https,
+// dropping an explicit :80 port so the default https port is used.
+func ForceHTTPS() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Scheme == "http" {
+				r.URL.Scheme = "https"
+				if r.URL.Port() == "80" {
+					r.URL.Host = strings.TrimSuffix(r.URL.Host, ":80")
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// ErrHostNotAllowed is returned by AllowHosts and DenyHosts Decorators for
+// requests to hosts their policy rejects.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// AllowHosts returns a Decorator that fails requests to any host but the
+// given ones with ErrHostNotAllowed, before sending them. A host of the form
+// "*.example.com" matches every subdomain of example.com, but not
+// example.com itself.
+func AllowHosts(hosts ...string) Decorator {
+	return hostPolicy(hosts, true)
+}
+
+// DenyHosts returns a Decorator that fails requests to any of the given hosts
+// with ErrHostNotAllowed, before sending them. Hosts are matched like in
+// AllowHosts.
+func DenyHosts(hosts ...string) Decorator {
+	return hostPolicy(hosts, false)
+}
+
+// hostPolicy returns a Decorator that only lets through requests whose host
+// matching one of hosts is equal to allow.
+func hostPolicy(hosts []string, allow bool) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if matchHost(hosts, r.URL.Hostname()) != allow {
+				return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, r.URL.Hostname())
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// matchHost reports whether host matches any of the given host patterns.
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrPrivateNetwork is returned by a BlockPrivateNetworks Decorator for
+// requests to private, loopback, link-local or unspecified addresses.
+var ErrPrivateNetwork = errors.New("request to private network")
+
+// BlockPrivateNetworks returns a Decorator that resolves the host of every
+// request and fails it with ErrPrivateNetwork, before sending it, if any of
+// its addresses is private, loopback, link-local or unspecified.
+//
+// The transport resolves the host again when dialing, so a DNS server that
+// changes its answers in between can get around this check; guarding the
+// dialer itself closes that gap.
+func BlockPrivateNetworks() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			host := r.URL.Hostname()
+			addrs, err := resolve(r, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				addr = addr.Unmap()
+				if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+					addr.IsLinkLocalMulticast() || addr.IsUnspecified() {
+					return nil, fmt.Errorf("%w: %s resolves to %s", ErrPrivateNetwork, host, addr)
+				}
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// resolve returns the IP addresses of host, which may be a literal address.
+func resolve(r *http.Request, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	return net.DefaultResolver.LookupNetIP(r.Context(), "ip", host)
+}
+
+const (
+	// SignatureHeader is the request header in which SignEd25519 puts the
+	// base64 encoded signature.
+	SignatureHeader = "X-Signature"
+	// SignatureKeyIDHeader is the request header in which SignEd25519 puts
+	// the ID of the signing key.
+	SignatureKeyIDHeader = "X-Signature-Key-Id"
+)
+
+// SignEd25519 returns a Decorator that signs the CanonicalRequest of every
+// request with priv and sets the signature and keyID in the SignatureHeader
+// and SignatureKeyIDHeader headers. The request body is left readable.
+func SignEd25519(keyID string, priv ed25519.PrivateKey) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := readBody(r)
+			if err != nil {
+				return nil, err
+			}
+			sig := ed25519.Sign(priv, CanonicalRequest(r, body))
+			r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+			r.Header.Set(SignatureKeyIDHeader, keyID)
+			return c.Do(r)
+		})
+	}
+}
+
+// SignatureTimestamp returns a Decorator that sets the given header of every
+// request to the current time, in Unix seconds, for servers that reject stale
+// signed requests. Responses with the status skewStatus whose body contains
+// skewBody, or with any body if skewBody is empty, are taken as clock skew
+// rejections: the Decorator then corrects its clock by the server's Date
+// header, for this and every later request, and retries once. Only the
+// first 4 KiB past the length of skewBody of those bodies are searched, and
+// request bodies of more than 10 MiB without a GetBody aren't retried.
+// Decorators that sign requests must come before it in Decorate to sign the
+// retry too.
+func SignatureTimestamp(header string, skewStatus int, skewBody string) Decorator {
+	var offset atomic.Int64
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.GetBody == nil {
+				if _, _, err := peekBody(r, maxBufferedBody); err != nil {
+					return nil, err
+				}
+			}
+			resendable := r.GetBody != nil || r.Body == nil || r.Body == http.NoBody
+			clock := clockFrom(r.Context())
+			stamp := func() {
+				now := clock.Now().Add(time.Duration(offset.Load()))
+				r.Header.Set(header, strconv.FormatInt(now.Unix(), 10))
+			}
+			stamp()
+			res, err := c.Do(r)
+			if err != nil || res.StatusCode != skewStatus || !resendable {
+				return res, err
+			}
+			body, err := io.ReadAll(io.LimitReader(res.Body, int64(len(skewBody))+maxErrorBody))
+			if err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+			res.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), res.Body), Closer: res.Body}
+			if !bytes.Contains(body, []byte(skewBody)) {
+				return res, nil
+			}
+			date, err := http.ParseTime(res.Header.Get("Date"))
+			if err != nil {
+				return res, nil
+			}
+			offset.Store(int64(date.Sub(clock.Now())))
+			if err := rewind(r); err != nil {
+				return nil, err
+			}
+			stamp()
+			return c.Do(r)
+		})
+	}
+}
+
+// CanonicalRequest returns the representation of r with the given body that
+// SignEd25519 signs: the method, host, request URI and hex encoded SHA-256 of
+// the body, each on its own line. Servers verify a signature by computing it
+// for the request they received.
+func CanonicalRequest(r *http.Request, body []byte) []byte {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	sum := sha256.Sum256(body)
+	return []byte(r.Method + "\n" + host + "\n" + r.URL.RequestURI() + "\n" + hex.EncodeToString(sum[:]))
+}
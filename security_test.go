@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// overTLS returns a Client that answers every request as if over TLS of the
+// given version, or without TLS if version is 0.
+func overTLS(version uint16) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusOK, "")
+		if version != 0 {
+			res.TLS = &tls.ConnectionState{Version: version}
+		}
+		return res, nil
+	})
+}
+
+func TestRequireTLSVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version uint16
+		wantErr bool
+	}{
+		{0, true},
+		{tls.VersionTLS11, true},
+		{tls.VersionTLS12, false},
+		{tls.VersionTLS13, false},
+	} {
+		c := Decorate(overTLS(tc.version), RequireTLSVersion(tls.VersionTLS12))
+		_, err := c.Do(newRequest(t, http.MethodGet, "https://example.com/", nil))
+		if got := errors.Is(err, ErrInsecureTLS); got != tc.wantErr {
+			t.Errorf("version %x: Do() error = %v, want ErrInsecureTLS: %v", tc.version, err, tc.wantErr)
+		}
+	}
+}
+
+func TestForceHTTPS(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://example.com/a":      "https://example.com/a",
+		"http://example.com:80/a":   "https://example.com/a",
+		"http://example.com:8080/a": "https://example.com:8080/a",
+		"https://example.com/a":     "https://example.com/a",
+	} {
+		var got string
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			got = r.URL.String()
+			return newResponse(r, http.StatusOK, ""), nil
+		}), ForceHTTPS())
+		do(t, c, newRequest(t, http.MethodGet, in, nil)).Body.Close()
+		if got != want {
+			t.Errorf("%s: sent to %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestHostPolicies(t *testing.T) {
+	allow := Decorate(respond(http.StatusOK, ""), AllowHosts("example.com", "*.example.org"))
+	deny := Decorate(respond(http.StatusOK, ""), DenyHosts("example.com", "*.example.org"))
+	for _, tc := range []struct {
+		url   string
+		match bool
+	}{
+		{"http://example.com/", true},
+		{"http://EXAMPLE.com.:8080/", true},
+		{"http://api.example.org/", true},
+		{"http://example.org/", false},
+		{"http://evil-example.com/", false},
+	} {
+		_, err := allow.Do(newRequest(t, http.MethodGet, tc.url, nil))
+		if got := err == nil; got != tc.match {
+			t.Errorf("AllowHosts: %s: Do() error = %v, want allowed: %v", tc.url, err, tc.match)
+		}
+		_, err = deny.Do(newRequest(t, http.MethodGet, tc.url, nil))
+		if got := errors.Is(err, ErrHostNotAllowed); got != tc.match {
+			t.Errorf("DenyHosts: %s: Do() error = %v, want denied: %v", tc.url, err, tc.match)
+		}
+	}
+}
+
+func TestBlockPrivateNetworks(t *testing.T) {
+	c := Decorate(respond(http.StatusOK, ""), BlockPrivateNetworks())
+	for url, blocked := range map[string]bool{
+		"http://127.0.0.1/":            true,
+		"http://10.1.2.3/":             true,
+		"http://[::1]/":                true,
+		"http://[::ffff:192.168.0.1]/": true,
+		"http://169.254.169.254/":      true,
+		"http://0.0.0.0/":              true,
+		"http://93.184.215.14/":        false,
+	} {
+		_, err := c.Do(newRequest(t, http.MethodGet, url, nil))
+		if got := errors.Is(err, ErrPrivateNetwork); got != blocked {
+			t.Errorf("%s: Do() error = %v, want blocked: %v", url, err, blocked)
+		}
+	}
+}
+
+func TestSignEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verified bool
+	var body string
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		sig, _ := base64.StdEncoding.DecodeString(r.Header.Get(SignatureHeader))
+		verified = r.Header.Get(SignatureKeyIDHeader) == "key-1" && ed25519.Verify(pub, CanonicalRequest(r, b), sig)
+		return newResponse(r, http.StatusOK, ""), nil
+	}), SignEd25519("key-1", priv))
+
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/a?b=c", unseekable("payload"))).Body.Close()
+	if !verified || body != "payload" {
+		t.Fatalf("verified = %v with body %q, want a valid signature and the body sent", verified, body)
+	}
+}
+
+func TestCanonicalRequest(t *testing.T) {
+	r := newRequest(t, http.MethodPut, "http://example.com/a?b=c", nil)
+	r.Host = "api.example.com"
+	want := "PUT\napi.example.com\n/a?b=c\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got := string(CanonicalRequest(r, nil)); got != want {
+		t.Fatalf("CanonicalRequest() = %q, want %q", got, want)
+	}
+}
+
+func TestSignatureTimestamp(t *testing.T) {
+	clock := newFakeClock()
+	serverTime := clock.Now().Add(time.Hour)
+	var calls int
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		stamp, _ := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+		if d := serverTime.Sub(time.Unix(stamp, 0)); d > time.Minute || d < -time.Minute {
+			res := newResponse(r, http.StatusUnauthorized, "clock skew")
+			res.Header.Set("Date", serverTime.UTC().Format(http.TimeFormat))
+			return res, nil
+		}
+		return newResponse(r, http.StatusOK, "signed"), nil
+	}), SignatureTimestamp("X-Timestamp", http.StatusUnauthorized, "skew"), WithClock(clock))
+
+	for i, want := range []int{2, 3} {
+		if got := bodyString(t, do(t, c, newRequest(t, http.MethodPost, "http://example.com/", nil))); got != "signed" {
+			t.Fatalf("request %d got %q, want it signed in time", i, got)
+		}
+		if calls != want {
+			t.Fatalf("after request %d, sent %d requests, want %d", i, calls, want)
+		}
+	}
+}
+
+func TestSignatureTimestampReadError(t *testing.T) {
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		res := newResponse(r, http.StatusUnauthorized, "")
+		res.Body = io.NopCloser(iotest.ErrReader(errFlaky))
+		return res, nil
+	}), SignatureTimestamp("X-Timestamp", http.StatusUnauthorized, "skew"))
+	if res, err := c.Do(newRequest(t, http.MethodGet, "http://example.com/", nil)); res != nil || err != errFlaky {
+		t.Fatalf("Do() = %v, %v, want %v", res, err, errFlaky)
+	}
+}
+
+func TestSignatureTimestampLimits(t *testing.T) {
+	large := strings.Repeat("x", 1<<16)
+	src := &countingReader{Reader: strings.NewReader(large)}
+	var calls int
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if r.Body != nil {
+			io.Copy(io.Discard, r.Body)
+		}
+		res := newResponse(r, http.StatusUnauthorized, "")
+		res.Body = io.NopCloser(src)
+		res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		return res, nil
+	}), SignatureTimestamp("X-Timestamp", http.StatusUnauthorized, "skew"))
+
+	res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+	ahead := src.n
+	if got := bodyString(t, res); got != large {
+		t.Fatalf("got %d bytes, want the whole body", len(got))
+	}
+	if max := len("skew") + maxErrorBody; ahead > max {
+		t.Fatalf("read %d bytes of the response, want at most %d", ahead, max)
+	}
+
+	calls = 0
+	src.Reader = strings.NewReader("skew")
+	body := unseekable(strings.Repeat("x", maxBufferedBody+1))
+	do(t, c, newRequest(t, http.MethodPost, "http://example.com/", body)).Body.Close()
+	if calls != 1 {
+		t.Fatalf("sent %d requests, want a body too large to resend sent once", calls)
+	}
+}
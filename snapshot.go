@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// A MetricSnapshot is the state of a Metric at a point in time, in a form
+// that serializes to JSON, or any other format, without a metrics backend.
+type MetricSnapshot struct {
+	Name string `json:"name"`
+	// Type is "counter", "gauge", "summary" for QuantileHistograms or
+	// "histogram" for BucketedHistograms.
+	Type string `json:"type"`
+	// Series holds one series per label combination, or a single one without
+	// labels for the metrics that aren't vectors.
+	Series []SeriesSnapshot `json:"series"`
+}
+
+// A SeriesSnapshot is the state of a series of a Metric. Its zero fields are
+// left out of its JSON encoding.
+type SeriesSnapshot struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	// Value is the value of a counter or gauge.
+	Value *float64 `json:"value,omitempty"`
+	// Count and Sum are the number and the sum of the observations of a
+	// histogram.
+	Count uint64 `json:"count,omitempty"`
+	Sum   int64  `json:"sum,omitempty"`
+	// Quantiles are the values of the quantiles of a summary by percentile,
+	// e.g. "99".
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	// Buckets are the counts of the buckets of a histogram by upper bound,
+	// "+Inf" being the overflow bucket.
+	Buckets map[string]uint64 `json:"buckets,omitempty"`
+}
+
+// SnapshotMetric returns the current state of c.
+func (c *AtomicCounter) SnapshotMetric() MetricSnapshot {
+	return MetricSnapshot{Name: c.name, Type: "counter", Series: []SeriesSnapshot{valueSeries(nil, float64(c.Value()))}}
+}
+
+// SnapshotMetric returns the current state of v.
+func (v *AtomicCounterVec) SnapshotMetric() MetricSnapshot {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	snapshot := MetricSnapshot{Name: v.name, Type: "counter", Series: []SeriesSnapshot{}}
+	for _, key := range seriesKeys(v.labels) {
+		snapshot.Series = append(snapshot.Series, valueSeries(copyLabels(v.labels[key]), float64(v.counters[key].Value())))
+	}
+	return snapshot
+}
+
+// SnapshotMetric returns the current state of g.
+func (g *AtomicGauge) SnapshotMetric() MetricSnapshot {
+	return MetricSnapshot{Name: g.name, Type: "gauge", Series: []SeriesSnapshot{valueSeries(nil, float64(g.Value()))}}
+}
+
+// SnapshotMetric returns the current state of h.
+func (h *QuantileHistogram) SnapshotMetric() MetricSnapshot {
+	return MetricSnapshot{Name: h.name, Type: "summary", Series: []SeriesSnapshot{h.series(nil)}}
+}
+
+// SnapshotMetric returns the current state of v.
+func (v *QuantileHistogramVec) SnapshotMetric() MetricSnapshot {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	snapshot := MetricSnapshot{Name: v.name, Type: "summary", Series: []SeriesSnapshot{}}
+	for _, key := range seriesKeys(v.labels) {
+		snapshot.Series = append(snapshot.Series, v.histograms[key].series(copyLabels(v.labels[key])))
+	}
+	return snapshot
+}
+
+// series returns the state of h as a series with the given labels.
+func (h *QuantileHistogram) series(labels map[string]string) SeriesSnapshot {
+	quantiles, count, sum := h.summary()
+	series := SeriesSnapshot{Labels: labels, Count: uint64(count), Sum: sum, Quantiles: map[string]float64{}}
+	for i, q := range h.quantiles {
+		series.Quantiles[strconv.Itoa(q)] = quantiles[i]
+	}
+	return series
+}
+
+// SnapshotMetric returns the current state of h.
+func (h *BucketedHistogram) SnapshotMetric() MetricSnapshot {
+	series := SeriesSnapshot{Sum: h.Sum(), Buckets: map[string]uint64{}}
+	for i, count := range h.Counts() {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = promFloat(h.bounds[i])
+		}
+		series.Buckets[le] = count
+		series.Count += count
+	}
+	return MetricSnapshot{Name: h.name, Type: "histogram", Series: []SeriesSnapshot{series}}
+}
+
+func valueSeries(labels map[string]string, value float64) SeriesSnapshot {
+	return SeriesSnapshot{Labels: labels, Value: &value}
+}
+
+// MarshalMetrics returns the JSON encoding of the MetricSnapshots of every
+// Metric recorded into by the instrumenting Decorators in the chain of c,
+// like Instrumentation and RetryMetrics, from outermost to innermost, e.g.
+// for periodic dumps. Metrics recorded into by several Decorators appear
+// once. The chain is followed like Shutdown follows it.
+func MarshalMetrics(c Client) ([]byte, error) {
+	seen := map[Metric]bool{}
+	snapshots := []MetricSnapshot{}
+	walk(c, func(c Client) {
+		i, ok := c.(*instrumented)
+		if !ok {
+			return
+		}
+		for _, m := range i.metrics {
+			if m, ok := m.(Metric); ok && !seen[m] {
+				seen[m] = true
+				snapshots = append(snapshots, m.SnapshotMetric())
+			}
+		}
+	})
+	return json.Marshal(snapshots)
+}
+
+// instrumented is the Client returned by the instrumenting Decorators, which
+// lets MarshalMetrics find the metrics they record into.
+type instrumented struct {
+	Client
+	next    Client
+	metrics []interface{}
+}
+
+// instrument returns the Client of an instrumenting Decorator decorating
+// next, which does what do does and records into the given metrics.
+func instrument(next Client, do func(*http.Request) (*http.Response, error), metrics ...interface{}) Client {
+	return &instrumented{Client: ClientFunc(do), next: next, metrics: metrics}
+}
+
+// Unwrap returns the Client that i decorates.
+func (i *instrumented) Unwrap() Client {
+	return i.next
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalMetrics(t *testing.T) {
+	requests, latency := NewCounter("requests"), NewHistogram("latency", 0, 1e12, 0, 50)
+	sizes := NewBucketedHistogram("inner_latency", 1e12)
+	retries, exhausted := NewCounter("retries"), NewCounter("exhausted")
+	var calls int
+	c := Decorate(flaky(1, &calls),
+		Instrumentation(requests, sizes),
+		FaultTolerance(1, 0),
+		RetryMetrics(retries, exhausted),
+		Instrumentation(requests, latency),
+	)
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+
+	data, err := MarshalMetrics(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshots []MetricSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		t.Fatal(err)
+	}
+	var names, types []string
+	for _, s := range snapshots {
+		names, types = append(names, s.Name), append(types, s.Type)
+	}
+	if want := []string{"requests", "latency", "retries", "exhausted", "inner_latency"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("metrics = %v, want %v from outermost to innermost, each once", names, want)
+	}
+	if want := []string{"counter", "summary", "counter", "counter", "histogram"}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("types = %v, want %v", types, want)
+	}
+	if v := snapshots[0].Series[0].Value; v == nil || *v != 3 {
+		t.Fatalf("requests = %v, want 3 recorded by both Instrumentations", v)
+	}
+	if v := snapshots[2].Series[0].Value; v == nil || *v != 1 {
+		t.Fatalf("retries = %v, want 1", v)
+	}
+	if s := snapshots[4].Series[0]; s.Count != 2 || s.Buckets["+Inf"] != 0 {
+		t.Fatalf("inner_latency = %+v, want 2 observations under the bound", s)
+	}
+}
+
+func TestMarshalMetricsBackendInstrumentation(t *testing.T) {
+	requests, latency := NewCounterVec("backend_requests"), NewHistogramVec("backend_latency", 0, 1e12, 0, 50)
+	c := Decorate(respond(http.StatusOK, ""), BackendInstrumentation(requests, latency))
+	do(t, c, newRequest(t, http.MethodGet, "http://b1/", nil)).Body.Close()
+
+	data, err := MarshalMetrics(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshots []MetricSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "backend_requests" || snapshots[1].Name != "backend_latency" {
+		t.Fatalf("metrics = %s, want both backend metrics", data)
+	}
+	if s := snapshots[0].Series; len(s) != 1 || s[0].Labels["backend"] != "b1" || s[0].Value == nil || *s[0].Value != 1 {
+		t.Fatalf("backend_requests = %+v, want 1 request to b1", s)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A Span records the events of a request for tracing, e.g. an OpenTelemetry
+// trace.Span adapted by calling its AddEvent with the attributes as
+// attribute.String values.
+type Span interface {
+	AddEvent(name string, attrs map[string]string)
+}
+
+// ContextWithSpan returns a copy of ctx carrying span, on which Decorators
+// record the key points of the lifecycle of requests with that context:
+//
+//   - "retry", before each retry, with the "attempt" about to be made;
+//   - "cache.hit" and "cache.stale", when a Cache serves a response;
+//   - "ratelimit.wait", when a rate limiter delays the request, with the
+//     "duration" of the wait.
+//
+// SpanEvents adds the request and its response.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+// AddSpanEvent records the given event on the Span carried by ctx, if any,
+// for Decorators to report their own events.
+func AddSpanEvent(ctx context.Context, name string, attrs map[string]string) {
+	if span, ok := ctx.Value(spanKey).(Span); ok {
+		span.AddEvent(name, attrs)
+	}
+}
+
+// SpanEvents returns a Decorator that records on the Span in the request
+// context, if any, an "http.request" event with the method and URL of every
+// request, its password redacted, and once the Client returns an
+// "http.response" event with the status code and the duration of the call,
+// or an "http.error" event with the error.
+func SpanEvents() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			ctx := r.Context()
+			AddSpanEvent(ctx, "http.request", map[string]string{"method": r.Method, "url": r.URL.Redacted()})
+			start := time.Now()
+			res, err := c.Do(r)
+			duration := time.Since(start).String()
+			if err != nil {
+				AddSpanEvent(ctx, "http.error", map[string]string{"error": err.Error(), "duration": duration})
+				return res, err
+			}
+			AddSpanEvent(ctx, "http.response", map[string]string{"status_code": strconv.Itoa(res.StatusCode), "duration": duration})
+			return res, nil
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxErrorBody is the number of bytes of a response body kept in an HTTPError.
+const maxErrorBody = 4 << 10
+
+// An HTTPError is returned in place of a response with an error status.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	// Body holds up to the first 4KiB of the response body.
+	Body []byte
+	// CorrelationID identifies the failed request, when known.
+	CorrelationID string
+}
+
+func (e *HTTPError) Error() string {
+	msg := "http status " + e.Status
+	if e.CorrelationID != "" {
+		msg += " (correlation ID " + e.CorrelationID + ")"
+	}
+	if len(e.Body) == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Body)
+}
+
+// newHTTPError returns an HTTPError for res, consuming and closing its body.
+func newHTTPError(res *http.Response) *HTTPError {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	drainAndClose(res.Body)
+	return &HTTPError{StatusCode: res.StatusCode, Status: res.Status, Body: body}
+}
+
+// ErrorOnStatus returns a Decorator that turns responses with any of the
+// given status codes into an *HTTPError. With no codes, every 4xx and 5xx
+// status code matches.
+func ErrorOnStatus(codes ...int) Decorator {
+	if len(codes) == 0 {
+		return ErrorOnStatusFunc(func(code int) bool { return code >= 400 })
+	}
+	match := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		match[code] = true
+	}
+	return ErrorOnStatusFunc(func(code int) bool { return match[code] })
+}
+
+// ErrorOnStatusFunc returns a Decorator that turns responses whose status
+// code satisfies match into an *HTTPError.
+func ErrorOnStatusFunc(match func(code int) bool) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil || !match(res.StatusCode) {
+				return res, err
+			}
+			return nil, newHTTPError(res)
+		})
+	}
+}
+
+// ServerErrors returns a Decorator that turns every 5xx response into an
+// *HTTPError carrying the correlation ID of the request: the one in its
+// context or, failing that, the value of the given header on the request or
+// on the response. It must be wrapped by the CorrelationID Decorator, i.e.
+// come before it in Decorate, to see the IDs that Decorator generates.
+func ServerErrors(header string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err != nil || res.StatusCode < 500 {
+				return res, err
+			}
+			httpErr := newHTTPError(res)
+			if id, ok := CorrelationIDFromContext(r.Context()); ok {
+				httpErr.CorrelationID = id
+			} else if id := r.Header.Get(header); id != "" {
+				httpErr.CorrelationID = id
+			} else {
+				httpErr.CorrelationID = res.Header.Get(header)
+			}
+			return nil, httpErr
+		})
+	}
+}
+
+// DescribeErrors returns a Decorator that wraps every error of the Client with
+// the method and URL of the request, its password redacted, and, when known,
+// its correlation ID, from its context or else the given request header, so
+// that logged errors tell which request failed. The original error stays
+// reachable with errors.Is and errors.As.
+func DescribeErrors(header string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			if err == nil {
+				return res, nil
+			}
+			id, ok := CorrelationIDFromContext(r.Context())
+			if !ok {
+				id = r.Header.Get(header)
+			}
+			if id == "" {
+				return res, fmt.Errorf("%s %s: %w", r.Method, r.URL.Redacted(), err)
+			}
+			return res, fmt.Errorf("%s %s (correlation ID %s): %w", r.Method, r.URL.Redacted(), id, err)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// EventStream returns a Decorator for server-sent events endpoints. It asks
+// for a text/event-stream response that isn't cached along the way, and marks
+// every request as streaming so that the Decorators it wraps which buffer
+// response bodies, like a Cache's, leave the stream alone.
+func EventStream() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set("Accept", "text/event-stream")
+			r.Header.Set("Cache-Control", "no-cache")
+			return c.Do(r.WithContext(context.WithValue(r.Context(), streamingKey, true)))
+		})
+	}
+}
+
+// IsStreaming reports whether r was marked as streaming by an EventStream
+// Decorator, in which case its response body must not be buffered.
+func IsStreaming(r *http.Request) bool {
+	streaming, _ := r.Context().Value(streamingKey).(bool)
+	return streaming
+}
+
+// ChannelBody returns a request body that streams the chunks received from
+// ch as they arrive, and ends once ch is closed, so that a payload produced
+// incrementally is sent without being buffered whole. Requests with such a
+// body have an unknown length, and are sent chunked. Closing the body doesn't
+// stop the producer, which should watch the request context instead.
+func ChannelBody(ch <-chan []byte) io.ReadCloser {
+	return &channelBody{ch: ch}
+}
+
+// channelBody is the body returned by ChannelBody.
+type channelBody struct {
+	ch     <-chan []byte
+	chunk  []byte
+	closed atomic.Bool
+}
+
+func (b *channelBody) Read(p []byte) (int, error) {
+	if b.closed.Load() {
+		return 0, errors.New("read on closed body")
+	}
+	for len(b.chunk) == 0 {
+		chunk, ok := <-b.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		b.chunk = chunk
+	}
+	n := copy(p, b.chunk)
+	b.chunk = b.chunk[n:]
+	return n, nil
+}
+
+func (b *channelBody) Close() error {
+	b.closed.Store(true)
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	var got http.Header
+	var streaming bool
+	c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got, streaming = r.Header.Clone(), IsStreaming(r)
+		return newResponse(r, http.StatusOK, ""), nil
+	}), EventStream())
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/events", nil)).Body.Close()
+	if !streaming || got.Get("Accept") != "text/event-stream" || got.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("streaming = %v with headers %v, want a streaming event-stream request", streaming, got)
+	}
+}
+
+func TestEventStreamBypassesCache(t *testing.T) {
+	c := Decorate(counting(nil), NewCache(time.Minute, 0).Decorator(), EventStream())
+	for _, want := range []string{"1", "2"} {
+		if body, status := get(t, c, "http://example.com/events"); body != want || status != "" {
+			t.Fatalf("got %s (%s), want %s from upstream", body, status, want)
+		}
+	}
+}
+
+func TestChannelBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header()["X-Transfer-Encoding"] = r.TransferEncoding
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	ch := make(chan []byte)
+	go func() {
+		for _, chunk := range []string{"one ", "", "two ", "three"} {
+			ch <- []byte(chunk)
+		}
+		close(ch)
+	}()
+	res := do(t, http.DefaultClient, newRequest(t, http.MethodPost, srv.URL, ChannelBody(ch)))
+	if got := bodyString(t, res); got != "one two three" || res.Header.Get("X-Transfer-Encoding") != "chunked" {
+		t.Fatalf("server got %q, %s, want every chunk, chunked", got, res.Header.Get("X-Transfer-Encoding"))
+	}
+}
+
+func TestChannelBodyReads(t *testing.T) {
+	ch := make(chan []byte, 2)
+	ch <- []byte("abcdef")
+	close(ch)
+	body := ChannelBody(ch)
+	var reads []string
+	p := make([]byte, 4)
+	for {
+		n, err := body.Read(p)
+		if err == io.EOF {
+			break
+		}
+		reads = append(reads, string(p[:n]))
+	}
+	if want := []string{"abcd", "ef"}; !reflect.DeepEqual(reads, want) {
+		t.Fatalf("reads = %q, want %q", reads, want)
+	}
+	body.Close()
+	if _, err := body.Read(p); err == nil {
+		t.Fatal("Read() after Close succeeded, want an error")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// stopwatch holds the duration measured by a Stopwatch Decorator.
+type stopwatch struct {
+	elapsed atomic.Int64
+}
+
+// Stopwatch returns a Decorator that measures how long a Client takes to
+// respond to each request and records it in the request context, where
+// Elapsed reads it once the call returns. Decorators wrapping a Stopwatch read
+// it from the context of the response's Request.
+func Stopwatch() Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			sw := &stopwatch{}
+			r = r.WithContext(context.WithValue(r.Context(), stopwatchKey, sw))
+			defer func(start time.Time) {
+				sw.elapsed.Store(int64(time.Since(start)))
+			}(time.Now())
+			return c.Do(r)
+		})
+	}
+}
+
+// Elapsed returns the duration recorded in ctx by a Stopwatch Decorator and
+// whether it has been recorded yet.
+func Elapsed(ctx context.Context) (time.Duration, bool) {
+	sw, ok := ctx.Value(stopwatchKey).(*stopwatch)
+	if !ok {
+		return 0, false
+	}
+	elapsed := time.Duration(sw.elapsed.Load())
+	return elapsed, elapsed > 0
+}
+
+// ErrSLAViolation is returned by an SLAGuard Decorator for requests that take
+// longer than allowed.
+var ErrSLAViolation = errors.New("SLA violation")
+
+// An SLAMode decides what an SLAGuard Decorator returns on a violation.
+type SLAMode int
+
+const (
+	// SLAWrap returns the response along with the ErrSLAViolation error.
+	SLAWrap SLAMode = iota
+	// SLAReplace closes the response and returns only the ErrSLAViolation error.
+	SLAReplace
+)
+
+// SLAGuard returns a Decorator that lets every request complete but reports
+// an error wrapping ErrSLAViolation for those that take longer than max, as
+// configured by mode. Requests that fail are returned unchanged.
+func SLAGuard(max time.Duration, mode SLAMode) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := c.Do(r)
+			elapsed := time.Since(start)
+			if err != nil || elapsed <= max {
+				return res, err
+			}
+			err = fmt.Errorf("%w: %s %s took %s, over %s", ErrSLAViolation, r.Method, r.URL, elapsed, max)
+			if mode == SLAReplace {
+				res.Body.Close()
+				return nil, err
+			}
+			return res, err
+		})
+	}
+}
+
+// TimeToFirstByte returns a Decorator that observes into ttfb the nanoseconds
+// elapsed between sending each request and receiving the first byte of its
+// response. It relies on an httptrace.ClientTrace, so the Client must be
+// backed by an http.Transport.
+func TimeToFirstByte(ttfb Histogram) Decorator {
+	return func(c Client) Client {
+		return instrument(c, func(r *http.Request) (*http.Response, error) {
+			start := time.Now()
+			trace := &httptrace.ClientTrace{
+				GotFirstResponseByte: func() {
+					ttfb.Observe(time.Since(start).Nanoseconds())
+				},
+			}
+			return c.Do(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+		}, ttfb)
+	}
+}
+
+// PropagateDeadline returns a Decorator that sets the given header to the
+// time remaining until the deadline of each request's context, rounded to the
+// millisecond, e.g. "500ms", so that servers can bound their own work. The
+// header is left alone for requests without a deadline.
+func PropagateDeadline(header string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if deadline, ok := r.Context().Deadline(); ok {
+				remaining := time.Until(deadline).Round(time.Millisecond)
+				if remaining < 0 {
+					remaining = 0
+				}
+				r.Header.Set(header, remaining.String())
+			}
+			return c.Do(r)
+		})
+	}
+}
+
+// DeadlineFromHeader returns a Decorator that bounds every request carrying
+// the given header with a timeout of the duration it holds, in the format of
+// time.ParseDuration, e.g. "500ms". Requests without the header, or with a
+// malformed or non-positive duration, are sent unchanged.
+func DeadlineFromHeader(header string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			timeout, err := time.ParseDuration(r.Header.Get(header))
+			if err != nil || timeout <= 0 {
+				return c.Do(r)
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			res, err := c.Do(r.WithContext(ctx))
+			if err != nil {
+				cancel()
+				return res, err
+			}
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		})
+	}
+}
+
+// cancelBody is a response body that cancels the context of its request when
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// ErrHardTimeout is returned by a HardTimeout Decorator for requests that
+// take longer than allowed.
+var ErrHardTimeout = errors.New("request timed out")
+
+// HardTimeout returns a Decorator that bounds every request by a timeout of
+// d, returning ErrHardTimeout once it passes, or the context error once the
+// request context is done, even if the Client ignores the cancellation. The
+// Timeout of the Overrides in the request context, if any, replaces d.
+//
+// This comes at a cost: the call runs in its own goroutine, which is
+// abandoned on timeout and lingers, holding its connection, until the Client
+// eventually returns. Any response it then returns is closed. Prefer a plain
+// context timeout with Clients known to honor it.
+func HardTimeout(d time.Duration) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			d := overrideTimeout(r.Context(), d)
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			type result struct {
+				res *http.Response
+				err error
+			}
+			done := make(chan result, 1)
+			go func() {
+				res, err := c.Do(r.WithContext(ctx))
+				done <- result{res, err}
+			}()
+
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			var err error
+			select {
+			case res := <-done:
+				if res.err != nil {
+					cancel()
+					return res.res, res.err
+				}
+				res.res.Body = &cancelBody{ReadCloser: res.res.Body, cancel: cancel}
+				return res.res, nil
+			case <-timer.C:
+				err = fmt.Errorf("%w: %s %s after %s", ErrHardTimeout, r.Method, r.URL, d)
+			case <-r.Context().Done():
+				err = r.Context().Err()
+			}
+			cancel()
+			go func() {
+				if res := <-done; res.err == nil {
+					res.res.Body.Close()
+				}
+			}()
+			return nil, err
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slow returns a Client that takes d to answer every request with a 200.
+func slow(d time.Duration) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		time.Sleep(d)
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+}
+
+func TestStopwatch(t *testing.T) {
+	var inside bool
+	c := Decorate(slow(10*time.Millisecond), Stopwatch(), func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := c.Do(r)
+			_, inside = Elapsed(r.Context())
+			return res, err
+		})
+	})
+	res := do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil))
+	res.Body.Close()
+	if inside {
+		t.Fatal("Elapsed is recorded in the caller's context, want only in the response's")
+	}
+	elapsed, ok := Elapsed(res.Request.Context())
+	if !ok || elapsed < 10*time.Millisecond {
+		t.Fatalf("Elapsed() = %v, %v, want at least 10ms", elapsed, ok)
+	}
+}
+
+func TestSLAGuard(t *testing.T) {
+	r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+	res, err := Decorate(slow(0), SLAGuard(time.Second, SLAReplace)).Do(r)
+	if err != nil || res == nil {
+		t.Fatalf("fast: Do() = %v, %v, want the response", res, err)
+	}
+
+	res, err = Decorate(slow(10*time.Millisecond), SLAGuard(time.Millisecond, SLAWrap)).Do(r)
+	if !errors.Is(err, ErrSLAViolation) || res == nil {
+		t.Fatalf("SLAWrap: Do() = %v, %v, want the response and %v", res, err, ErrSLAViolation)
+	}
+	res, err = Decorate(slow(10*time.Millisecond), SLAGuard(time.Millisecond, SLAReplace)).Do(r)
+	if !errors.Is(err, ErrSLAViolation) || res != nil {
+		t.Fatalf("SLAReplace: Do() = %v, %v, want only %v", res, err, ErrSLAViolation)
+	}
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	ttfb := NewHistogram("ttfb", 0, int64(time.Minute), 0, 100)
+	c := Decorate(srv.Client(), TimeToFirstByte(ttfb))
+	bodyString(t, do(t, c, newRequest(t, http.MethodGet, srv.URL, nil)))
+	if _, count, sum := ttfb.summary(); count != 1 || sum < int64(10*time.Millisecond) {
+		t.Fatalf("observed %d values summing to %v, want one of at least 10ms", count, time.Duration(sum))
+	}
+}
+
+func TestPropagateDeadline(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), PropagateDeadline("X-Timeout"))
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil)).Body.Close()
+	if v := got.Get("X-Timeout"); v != "" {
+		t.Fatalf("without deadline: X-Timeout = %q, want none", v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	do(t, c, newRequest(t, http.MethodGet, "http://example.com/", nil).WithContext(ctx)).Body.Close()
+	d, err := time.ParseDuration(got.Get("X-Timeout"))
+	if err != nil || d <= 59*time.Second || d > time.Minute || d%time.Millisecond != 0 {
+		t.Fatalf("X-Timeout = %q, want about 1m in milliseconds", got.Get("X-Timeout"))
+	}
+}
+
+func TestDeadlineFromHeader(t *testing.T) {
+	for header, want := range map[string]time.Duration{"30s": 30 * time.Second, "": 0, "soon": 0, "-1s": 0} {
+		var ctx context.Context
+		c := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+			ctx = r.Context()
+			return newResponse(r, http.StatusOK, ""), nil
+		}), DeadlineFromHeader("X-Timeout"))
+		r := newRequest(t, http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("X-Timeout", header)
+		res := do(t, c, r)
+
+		deadline, ok := ctx.Deadline()
+		if left := time.Until(deadline); ok != (want > 0) || ok && (left > want || left < want-time.Second) {
+			t.Errorf("X-Timeout %q: deadline in %v, want %v", header, left, want)
+		}
+		res.Body.Close()
+		if ok && ctx.Err() == nil {
+			t.Errorf("X-Timeout %q: closing the body left the timeout running", header)
+		}
+	}
+}
+
+func TestHardTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	// The Client ignores the cancellation of its requests.
+	stuck := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		<-release
+		return newResponse(r, http.StatusOK, ""), nil
+	})
+	start := time.Now()
+	_, err := Decorate(stuck, HardTimeout(10*time.Millisecond)).Do(newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if !errors.Is(err, ErrHardTimeout) || time.Since(start) > time.Second {
+		t.Fatalf("Do() error = %v after %v, want %v after 10ms", err, time.Since(start), ErrHardTimeout)
+	}
+
+	res := do(t, Decorate(slow(0), HardTimeout(time.Second)), newRequest(t, http.MethodGet, "http://example.com/", nil))
+	if err := res.Request.Context().Err(); err != nil {
+		t.Fatalf("before Close: context error = %v, want nil", err)
+	}
+	res.Body.Close()
+	if res.Request.Context().Err() == nil {
+		t.Fatal("after Close: the timeout is still running")
+	}
+}
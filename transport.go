@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// H2CClient returns a base Client that speaks HTTP/2 over cleartext TCP with
+// prior knowledge, i.e. without an HTTP/1.1 Upgrade or ALPN negotiation. It
+// only works with servers that accept unencrypted HTTP/2 connections.
+func H2CClient() *http.Client {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+}
+
+// A Prewarmer owns a base Client whose transport keeps idle connections to a
+// fixed set of backends, and opens those connections ahead of the first
+// requests to avoid paying for their setup on the request path.
+type Prewarmer struct {
+	client   *http.Client
+	scheme   string
+	conns    int
+	backends []string
+}
+
+// NewPrewarmer returns a Prewarmer for the given backend hosts, reached with
+// the given URL scheme, that keeps up to conns idle connections to each of
+// them. No connection is opened until Warm is called.
+func NewPrewarmer(scheme string, conns int, backends ...string) *Prewarmer {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = conns
+	if n := conns * len(backends); n > transport.MaxIdleConns {
+		transport.MaxIdleConns = n
+	}
+	return &Prewarmer{
+		client:   &http.Client{Transport: transport},
+		scheme:   scheme,
+		conns:    conns,
+		backends: backends,
+	}
+}
+
+// Client returns the base Client whose connections are kept warm. Decorate it
+// like any other base Client.
+func (p *Prewarmer) Client() *http.Client {
+	return p.client
+}
+
+// Warm opens connections to every backend by sending it conns concurrent HEAD
+// requests, which leave their connections idle in the pool once answered.
+// Call it on startup and again whenever the idle connections may have timed
+// out. How many connections end up open is best effort: a backend that
+// answers quickly may have some of the requests share a connection.
+func (p *Prewarmer) Warm(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, backend := range p.backends {
+		u := (&url.URL{Scheme: p.scheme, Host: backend, Path: "/"}).String()
+		for i := 0; i < p.conns; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := p.warm(ctx, u)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("warming %s: %w", backend, err))
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (p *Prewarmer) warm(ctx context.Context, u string) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(r)
+	if err != nil {
+		return err
+	}
+	drainAndClose(res.Body)
+	return nil
+}
+
+// ExpectContinue returns a Decorator that sets "Expect: 100-continue" on every
+// request with a body larger than minBytes, or of unknown length, so that the
+// body is only sent once the server agrees to take it, and a request the
+// server rejects from its headers alone, e.g. with 401 or 413, doesn't upload
+// a body for nothing.
+//
+// The header only has an effect with an http.Transport whose
+// ExpectContinueTimeout is set: it then waits up to that long for the
+// server's 100 Continue, sending the body anyway if none comes. With a zero
+// ExpectContinueTimeout, as in a zero Transport, the body is sent right away.
+// http.DefaultTransport waits for one second.
+func ExpectContinue(minBytes int64) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Body != nil && r.Body != http.NoBody && (r.ContentLength > minBytes || r.ContentLength <= 0) {
+				r.Header.Set("Expect", "100-continue")
+			}
+			return c.Do(r)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestH2CClient(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	res := do(t, H2CClient(), newRequest(t, http.MethodGet, srv.URL, nil))
+	if got := bodyString(t, res); got != "HTTP/2.0" {
+		t.Fatalf("server saw %q, want HTTP/2.0", got)
+	}
+}
+
+func TestPrewarmer(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond) // keeps the warming requests from sharing connections
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	p := NewPrewarmer("http", 2, srv.Listener.Addr().String())
+	if err := p.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		do(t, p.Client(), newRequest(t, http.MethodGet, srv.URL, nil)).Body.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 2 {
+		t.Fatalf("server saw %d connections, want the 2 warmed ones", conns)
+	}
+}
+
+func TestPrewarmerUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := srv.Listener.Addr().String()
+	srv.Close()
+
+	if err := NewPrewarmer("http", 1, addr).Warm(context.Background()); err == nil {
+		t.Fatal("warming a closed backend: got no error")
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	var got http.Header
+	c := Decorate(headersOf(&got), ExpectContinue(4))
+	for _, tc := range []struct {
+		body io.Reader
+		want string
+	}{
+		{nil, ""},
+		{strings.NewReader("tiny"), ""},
+		{strings.NewReader("large body"), "100-continue"},
+		{io.MultiReader(strings.NewReader("unknown")), "100-continue"},
+	} {
+		do(t, c, newRequest(t, http.MethodPost, "http://example.com/", tc.body)).Body.Close()
+		if expect := got.Get("Expect"); expect != tc.want {
+			t.Errorf("Expect = %q, want %q", expect, tc.want)
+		}
+	}
+}